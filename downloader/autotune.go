@@ -0,0 +1,189 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+)
+
+const AUTO_CONNECTIONS_INTERVAL = 2 * time.Second // the interval to measure the throughput and tune the connections
+const AUTO_CONNECTIONS_MIN_GAIN = 0.05            // an extra connection must add 5% throughput to be kept
+const AUTO_CONNECTIONS_PER_PROXY = 16             // the maximum number of connections per proxy if not provided
+
+/*
+ConnectionTuner grows and shrinks the number of active downloaders of every proxy in the AIMD style, so the number
+of connections does not have to be picked by hand.
+
+Every proxy starts with one active downloader. The throughput of the proxy is measured every
+AUTO_CONNECTIONS_INTERVAL and one more downloader is activated as long as the last one added at least
+AUTO_CONNECTIONS_MIN_GAIN to the throughput. Otherwise the last downloader is deactivated and the proxy stops
+growing. If the downloads through the proxy fail, e.g. the origin starts refusing the connections, the number of
+active downloaders is halved and the proxy stops growing as well.
+
+The inactive downloaders are parked, i.e. removed from the downloader queue, like the benched downloaders of a
+tripped proxy.
+*/
+type ConnectionTuner struct {
+	mutex   sync.Mutex
+	proxies map[*Proxy]*ProxyTuning // the downloaders without proxy are tuned under nil
+}
+
+type ProxyTuning struct {
+	downloaders  []*Downloader
+	limit        int // the number of active downloaders
+	admitted     map[*Downloader]bool
+	parked       []*Downloader
+	lastReceived uint64
+	baseline     float64 // the throughput before the last downloader was activated, in bytes per second
+	isSaturated  bool    // the proxy stops growing
+	failures     int     // the number of failed downloads since the last tuning
+}
+
+func NewConnectionTuner(dc DownloaderCluster) *ConnectionTuner {
+	ct := &ConnectionTuner{proxies: make(map[*Proxy]*ProxyTuning)}
+	for _, dwn := range dc {
+		pt, ok := ct.proxies[dwn.proxy]
+		if !ok {
+			pt = &ProxyTuning{limit: 1, admitted: make(map[*Downloader]bool)}
+			ct.proxies[dwn.proxy] = pt
+			dwn.telemetry.ReportConnectionLimit(dwn.proxy, pt.limit)
+		}
+		pt.downloaders = append(pt.downloaders, dwn)
+	}
+	return ct
+}
+
+// Park keeps the downloader aside if its proxy has enough active downloaders, it returns false if it is active.
+func (ct *ConnectionTuner) Park(dwn *Downloader) bool {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+
+	pt, ok := ct.proxies[dwn.proxy]
+	if !ok {
+		return false
+	}
+
+	if pt.admitted[dwn] {
+		if len(pt.admitted) <= pt.limit {
+			return false
+		}
+		// the limit has been lowered since the downloader was admitted
+		delete(pt.admitted, dwn)
+	} else if len(pt.admitted) < pt.limit {
+		pt.admitted[dwn] = true
+		return false
+	}
+
+	pt.parked = append(pt.parked, dwn)
+	return true
+}
+
+// ReportDownloadResult counts the failed and throttled downloads, both mean that the proxy has too many connections.
+func (ct *ConnectionTuner) ReportDownloadResult(dwn *Downloader, result DownloadResult) {
+	if !IsProxyFailure(result) && result != THROTTLED {
+		return
+	}
+
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+
+	if pt, ok := ct.proxies[dwn.proxy]; ok {
+		pt.failures++
+	}
+}
+
+// Limit returns the number of active downloaders of the proxy.
+func (ct *ConnectionTuner) Limit(proxy *Proxy) int {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+
+	if pt, ok := ct.proxies[proxy]; ok {
+		return pt.limit
+	}
+	return 0
+}
+
+/*
+Tune measures the throughput of every proxy since the last tuning, elapsed ago, and adjusts the number of active
+downloaders. It returns the parked downloaders which become active and must be put back to the downloader queue.
+*/
+func (ct *ConnectionTuner) Tune(elapsed time.Duration) []*Downloader {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+
+	unparked := []*Downloader{}
+	for proxy, pt := range ct.proxies {
+		received := uint64(0)
+		for _, dwn := range pt.downloaders {
+			received += dwn._received.Load()
+		}
+		throughput := float64(received-pt.lastReceived) / elapsed.Seconds()
+		pt.lastReceived = received
+		failures := pt.failures
+		pt.failures = 0
+
+		oldLimit := pt.limit
+		if failures != 0 {
+			// multiplicative decrease, the origin or the proxy refuses more connections
+			pt.limit = max(1, pt.limit/2)
+			pt.isSaturated = true
+		} else if pt.isSaturated || pt.limit >= len(pt.downloaders) || throughput == 0 {
+			// nothing to tune, or nothing is downloading to measure
+		} else if pt.baseline == 0 || throughput >= pt.baseline*(1+AUTO_CONNECTIONS_MIN_GAIN) {
+			// additive increase as long as the extra connections add bandwidth
+			pt.baseline = throughput
+			pt.limit++
+		} else {
+			// the last connection did not add bandwidth
+			pt.limit = max(1, pt.limit-1)
+			pt.isSaturated = true
+		}
+
+		if pt.limit != oldLimit {
+			// the telemetry is shared by the downloaders of the proxy
+			telemetry := pt.downloaders[0].telemetry
+			telemetry.Logger().Println("Tune() proxy:", ProxyAddress(proxy), "connections:", oldLimit, "->", pt.limit, "throughput:", throughput, "failures:", failures) // TODO telemetry
+			telemetry.ReportConnectionLimit(proxy, pt.limit)
+		}
+
+		for len(pt.admitted) < pt.limit && len(pt.parked) != 0 {
+			dwn := pt.parked[0]
+			pt.parked = pt.parked[1:]
+			pt.admitted[dwn] = true
+			unparked = append(unparked, dwn)
+		}
+	}
+
+	return unparked
+}
+
+// Run tunes the connections every AUTO_CONNECTIONS_INTERVAL until done is closed.
+func (ct *ConnectionTuner) Run(downloaderQueue chan *Downloader, events *SchedulerEvents, done <-chan struct{}) {
+	ticker := time.NewTicker(AUTO_CONNECTIONS_INTERVAL)
+	defer ticker.Stop()
+
+	lastTime := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			unparked := ct.Tune(now.Sub(lastTime))
+			lastTime = now
+
+			for _, dwn := range unparked {
+				downloaderQueue <- dwn
+			}
+			if len(unparked) != 0 {
+				events.Notify()
+			}
+		}
+	}
+}
+
+// ProxyAddress returns the address of the proxy, or "direct" for the downloaders without proxy.
+func ProxyAddress(proxy *Proxy) string {
+	if proxy == nil {
+		return "direct"
+	}
+	return proxy.Address()
+}
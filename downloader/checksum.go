@@ -0,0 +1,76 @@
+package downloader
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+type Checksum struct {
+	algorithm string // sha256, sha1 or md5, empty if no checksum is provided
+	digest    string // in lowercase hex
+}
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+/*
+ParseChecksum parses a checksum in the format of '<algorithm>:<hex digest>', e.g. 'sha256:e3b0c442...'.
+The supported algorithms are sha256, sha1 and md5.
+*/
+func ParseChecksum(raw string) (Checksum, error) {
+	rawAlgorithm, rawDigest, found := strings.Cut(strings.TrimSpace(raw), ":")
+	if !found {
+		return Checksum{}, fmt.Errorf("the checksum must be in the format of '<algorithm>:<hex digest>'")
+	}
+
+	algorithm := strings.ToLower(rawAlgorithm)
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		return Checksum{}, fmt.Errorf("unsupported checksum algorithm: %s", rawAlgorithm)
+	}
+
+	digest := strings.ToLower(rawDigest)
+	decoded, err := hex.DecodeString(digest)
+	if err != nil || len(decoded) != newHash().Size() {
+		return Checksum{}, fmt.Errorf("invalid %s digest: %s", algorithm, rawDigest)
+	}
+
+	return Checksum{algorithm: algorithm, digest: digest}, nil
+}
+
+func (c Checksum) IsEmpty() bool {
+	return c.algorithm == ""
+}
+
+func (c Checksum) String() string {
+	if c.IsEmpty() {
+		return ""
+	}
+	return c.algorithm + ":" + c.digest
+}
+
+// ComputeFile computes the digest of the file with the same algorithm as the checksum.
+func (c Checksum) ComputeFile(path string) (Checksum, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Checksum{}, err
+	}
+	defer f.Close()
+
+	h := checksumAlgorithms[c.algorithm]()
+	if _, err := io.Copy(h, f); err != nil {
+		return Checksum{}, err
+	}
+
+	return Checksum{algorithm: c.algorithm, digest: hex.EncodeToString(h.Sum(nil))}, nil
+}
@@ -0,0 +1,1107 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type UserRequest struct {
+	url          string
+	dest         string
+	checksum     Checksum    // the expected checksum of the downloaded file, empty if not provided
+	headers      http.Header // extra request headers, nil if not provided
+	expectedSize *uint64     // the expected content length in bytes, nil if not provided
+	priority     int         // resources with a higher priority are downloaded first
+	mirrors      []string    // alternative urls of the same file, tried in order if the url is not available
+	rateLimit    uint64      // in bytes per second, 0 means unlimited
+}
+
+type ResourceRequest struct {
+	url           string
+	dest          string
+	contentLength uint64 // in bytes
+	isAcceptRange bool
+	isStreaming   bool   // the length is unknown, contentLength is 0 and isAcceptRange is false
+	etag          string // empty if not provided by the server
+	lastModified  string // empty if not provided by the server
+	checksum      Checksum
+	headers       http.Header
+	expectedSize  *uint64
+	priority      int
+	rateLimit     uint64
+	status        ResourceRequestStatus
+	errorClass    ErrorClass // the kind of failure if not available, except SIZE_MISMATCH
+	fault         PreflightFault
+	failedBy      []*Downloader // the downloaders which could not reach the resource, nil if there is none
+	fetchedBy     *Downloader
+}
+
+type ResourceRequestStatus int
+
+const (
+	AVAILABLE ResourceRequestStatus = iota
+	NOT_FOUND
+	CONNECTION_TIMEOUT
+	CONNECTION_REFUSED
+	SIZE_MISMATCH
+	CONNECTION_FAILED // any other error, see errorClass
+)
+
+const PREFLIGHT_MAX_PROXIES = 3 // the number of proxy IPs to try before a resource is declared unreachable
+
+func IsConnectionFailure(status ResourceRequestStatus) bool {
+	return status == CONNECTION_TIMEOUT || status == CONNECTION_REFUSED || status == CONNECTION_FAILED
+}
+
+// PreflightFault tells who was at fault when a resource could not be reached through a proxy.
+type PreflightFault int
+
+const (
+	NO_FAULT      PreflightFault = iota
+	PROXY_FAULT                  // failed through some proxies, but available through another one
+	ORIGIN_FAULT                 // failed through every proxy tried
+	UNKNOWN_FAULT                // failed through the only proxy IP, there is no other proxy to tell
+)
+
+type DownloadResult int
+
+const (
+	CLIENT_RETURNED_ERROR DownloadResult = iota
+	STATUS_CODE_NOT_2XX
+	READER_RETURNED_ERROR
+	RESOURCE_CHANGED
+	SPECULATION_CANCELLED
+	THROTTLED // 429 or 503, the segment is retried later without losing ttl until ERR_THROTTLED gives up
+	// the response is not the requested range, the resource is downgraded to a single segment without range
+	RANGE_MISMATCH
+	INTERRUPTED // the context is done, the segment is left pending without losing ttl
+	// no byte is received within the idle-read timeout, the segment is retried from its ack, see Timeouts
+	STALLED
+	WRITE_FAILED // the received data can not be written to the destination file, see ERR_WRITE
+	READ_SUCCESS
+)
+
+/*
+DownloaderClient sends the requests of a downloader. The request is cancelled once the context is done, including
+the reading of the response body, so the timeouts are given as the deadlines of the context.
+*/
+type DownloaderClient interface {
+	Do(ctx context.Context, req *http.Request) (*http.Response, error)
+}
+
+type DownloaderClientImpl http.Client
+
+func (client *DownloaderClientImpl) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return (*http.Client)(client).Do(req.WithContext(ctx))
+}
+
+type Downloader struct {
+	client    DownloaderClient
+	proxy     *Proxy             // shared by all downloaders of the same proxy
+	rateLimit *TokenBucket       // shared by all downloaders of the cluster, nil means unlimited
+	limiter   *ConnectionLimiter // shared by all downloaders of the cluster, nil means no limit of the connections per host
+	idleRead  time.Duration      // the idle-read timeout of the response body, 0 means no timeout
+	telemetry *Telemetry         // shared by all downloaders of the cluster, nil means nothing is reported or logged
+	_received atomic.Uint64      // the number of bytes received in total, sampled by ConnectionTuner
+}
+
+// ProxyIp returns the IP of the proxy of the downloader, empty for the downloaders without proxy.
+func (dwn *Downloader) ProxyIp() string {
+	if dwn.proxy == nil {
+		return ""
+	}
+	return dwn.proxy.Ip()
+}
+
+// FetchResourceRequest fetches the information of the resource with a HEAD request.
+func (dwn *Downloader) FetchResourceRequest(ctx context.Context, userRequest UserRequest) ResourceRequest {
+	rr := ResourceRequest{
+		url:           userRequest.url,
+		dest:          userRequest.dest,
+		checksum:      userRequest.checksum,
+		headers:       userRequest.headers,
+		expectedSize:  userRequest.expectedSize,
+		priority:      userRequest.priority,
+		rateLimit:     userRequest.rateLimit,
+		contentLength: 0,
+		isAcceptRange: false,
+		fetchedBy:     dwn}
+
+	req, err := http.NewRequest("HEAD", userRequest.url, nil)
+	if err != nil {
+		rr.status = CONNECTION_FAILED
+		rr.errorClass = ERR_OTHER
+		return rr
+	}
+	SetRequestHeaders(req, userRequest.headers)
+
+	// the connection is released before the range probe, which waits for another one
+	if err := dwn.AcquireConnection(ctx, req.URL.Hostname()); err != nil {
+		dwn.telemetry.Logger().Println("FetchResourceRequest() interrupted, url:", userRequest.url, "error:", err) // TODO telemetry
+		rr.errorClass = ClassifyError(err)
+		rr.status = ConnectionFailureStatus(rr.errorClass)
+		return rr
+	}
+	resp, err := dwn.client.Do(ctx, req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	dwn.ReleaseConnection(req.URL.Hostname())
+
+	if err != nil {
+		rr.errorClass = ClassifyError(err)
+		rr.status = ConnectionFailureStatus(rr.errorClass)
+		dwn.telemetry.Logger().Println("FetchResourceRequest() failed, url:", userRequest.url, "error:", err) // TODO telemetry
+		return rr
+	}
+
+	// many servers reject HEAD, omit the length or do not advertise the range support in the response of HEAD
+	if resp.StatusCode != 200 || resp.ContentLength < 0 || resp.Header.Get("Accept-Ranges") == "" {
+		dwn.telemetry.Logger().Println("FetchResourceRequest() fall back to range probe, url:", userRequest.url, "status code:", resp.StatusCode) // TODO telemetry
+		return dwn.ProbeResourceRequest(ctx, rr)
+	}
+
+	rr.contentLength = uint64(resp.ContentLength)
+	rr.isAcceptRange = resp.Header.Get("Accept-Ranges") == "bytes"
+	rr.etag = resp.Header.Get("ETag")
+	rr.lastModified = resp.Header.Get("Last-Modified")
+
+	return rr.CheckExpectedSize()
+}
+
+// CheckExpectedSize returns the resource request as AVAILABLE, or SIZE_MISMATCH if the length is not the expected size.
+func (rr ResourceRequest) CheckExpectedSize() ResourceRequest {
+	if rr.expectedSize != nil && !rr.isStreaming && *rr.expectedSize != rr.contentLength {
+		rr.status = SIZE_MISMATCH
+		return rr
+	}
+
+	rr.status = AVAILABLE
+	return rr
+}
+
+func ConnectionFailureStatus(class ErrorClass) ResourceRequestStatus {
+	switch class {
+	case ERR_DIAL_TIMEOUT, ERR_TIMEOUT:
+		return CONNECTION_TIMEOUT
+	case ERR_CONNECTION_REFUSED:
+		return CONNECTION_REFUSED
+	default:
+		return CONNECTION_FAILED
+	}
+}
+
+// SetRequestHeaders adds the extra headers of the user request to the request, the existing headers are overwritten.
+func SetRequestHeaders(req *http.Request, headers http.Header) {
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+}
+
+/*
+Download downloads the segment through the downloader. The request is cancelled once the context is done, and the
+segment is returned to pending with the progress saved to the journal, see INTERRUPTED.
+
+The request is also cancelled if a read of the response body takes longer than the idle-read timeout. The stalled
+segment is returned to pending at its ack, it loses a ttl only if nothing was received or the resource does not accept
+ranges, see STALLED.
+*/
+func (dwn *Downloader) Download(ctx context.Context, seg *ResourceSegment) DownloadResult {
+	dwn.telemetry.ReportDownloadingSegment(dwn, seg)
+	defer dwn.telemetry.ReportDownloadSettled(dwn, seg)
+
+	if err := seg.StartDownload(dwn); err != nil {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) failed, status: WRITE_FAILED url:", seg.resource.url, "dest:", seg.resource.dest, "error:", err) // TODO telemetry
+		seg.FailDownload(ERR_WRITE)
+		return WRITE_FAILED
+	}
+
+	downloadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	seg.SetDownloadCancel(cancel)
+
+	if !seg.IsSpeculationAlive() {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) skipped, status: SPECULATION_CANCELLED url:", seg.resource.url) // TODO telemetry
+		seg.CancelDownload()
+		return SPECULATION_CANCELLED
+	}
+
+	if seg.resource.IsChanged() {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) skipped, status: RESOURCE_CHANGED url:", seg.resource.url) // TODO telemetry
+		seg.AbortDownload()
+		return RESOURCE_CHANGED
+	}
+
+	if seg.IsSuperseded() {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) skipped, status: RANGE_MISMATCH url:", seg.resource.url) // TODO telemetry
+		seg.AbortDownload()
+		return RANGE_MISMATCH
+	}
+
+	if ctx.Err() != nil {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) skipped, status: INTERRUPTED url:", seg.resource.url) // TODO telemetry
+		seg.RequeueDownload()
+		return INTERRUPTED
+	}
+
+	req, err := http.NewRequestWithContext(downloadCtx, "GET", seg.resource.url, nil)
+	if err != nil {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) failed, status: CLIENT_RETURNED_ERROR url:", seg.resource.url, "error:", err) // TODO telemetry
+		seg.FailDownload(ERR_OTHER)
+		return CLIENT_RETURNED_ERROR
+	}
+	SetRequestHeaders(req, seg.resource.headers)
+
+	// From ack to to-1, try to continue download from failed point
+	// the segment can be split during the download, the response is checked against the requested range
+	requestedFrom, requestedTo := seg.Rewind()
+	if seg.resource.IsAcceptRange() {
+		req.Header.Add("Range", "bytes="+fmt.Sprint(requestedFrom)+"-"+fmt.Sprint(requestedTo-1))
+		// The server sends the full content instead of the range if the resource has changed
+		if validator := seg.resource.Validator(); validator != "" {
+			req.Header.Add("If-Range", validator)
+		}
+	}
+
+	resp, err := dwn.client.Do(downloadCtx, req)
+
+	if err != nil && !seg.IsSpeculationAlive() {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) cancelled, status: SPECULATION_CANCELLED url:", seg.resource.url) // TODO telemetry
+		seg.CancelDownload()
+		return SPECULATION_CANCELLED
+	}
+
+	if err != nil && seg.IsSuperseded() {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) cancelled, status: RANGE_MISMATCH url:", seg.resource.url) // TODO telemetry
+		seg.AbortDownload()
+		return RANGE_MISMATCH
+	}
+
+	if err != nil && seg.IsDoneBySpeculative() {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) done by speculative download, status: READ_SUCCESS url:", seg.resource.url) // TODO telemetry
+		seg.AcknowledgeAll()
+		seg.FinishDownload()
+		return READ_SUCCESS
+	}
+
+	if err != nil && ctx.Err() != nil {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) interrupted, status: INTERRUPTED url:", seg.resource.url) // TODO telemetry
+		seg.RequeueDownload()
+		return INTERRUPTED
+	}
+
+	if err != nil {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) failed, status: CLIENT_RETURNED_ERROR url:", seg.resource.url, "error:", err) // TODO telemetry
+		seg.FailDownload(ClassifyError(err))
+		return CLIENT_RETURNED_ERROR
+	}
+
+	defer resp.Body.Close()
+
+	if IsThrottlingStatusCode(resp.StatusCode) {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) failed, status: THROTTLED url:", seg.resource.url, "status code:", resp.StatusCode) // TODO telemetry
+		seg._retryAfter = ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		seg.ThrottleDownload()
+		return THROTTLED
+	}
+
+	if resp.StatusCode != 200 && resp.StatusCode != 206 {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) failed, status: STATUS_CODE_NOT_2XX url:", seg.resource.url, "status code:", resp.StatusCode) // TODO telemetry
+		seg.FailDownload(ClassifyStatusCode(resp.StatusCode))
+		return STATUS_CODE_NOT_2XX
+	}
+
+	if seg.resource.IsChangedResponse(resp) {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) failed, status: RESOURCE_CHANGED url:", seg.resource.url) // TODO telemetry
+		seg.resource.MarkChanged()
+		seg.AbortDownload()
+		return RESOURCE_CHANGED
+	}
+
+	// the server may ignore the range and send the full content, or send another range
+	if seg.resource.IsAcceptRange() && !IsRangeResponse(resp, requestedFrom, requestedTo, seg.resource.contentLength) {
+		dwn.telemetry.Logger().Println("Download(*ResourceSegment) failed, status: RANGE_MISMATCH url:", seg.resource.url, "status code:", resp.StatusCode, "content range:", resp.Header.Get("Content-Range")) // TODO telemetry
+		if !seg.IsSpeculative() && seg.resource.Downgrade(seg) {
+			seg.RequeueDownload()
+		} else {
+			seg.AbortDownload()
+		}
+		return RANGE_MISMATCH
+	}
+
+	startAck := seg.ack
+	isStalled := atomic.Bool{}
+	var stallTimer *time.Timer = nil
+	if dwn.idleRead != 0 {
+		stallTimer = time.AfterFunc(dwn.idleRead, func() {
+			isStalled.Store(true)
+			cancel()
+		})
+		defer stallTimer.Stop()
+	}
+
+	buf := make([]byte, 1024*1024*10) // 10MB buffer
+	for {
+		n, err := resp.Body.Read(buf)
+		if stallTimer != nil {
+			stallTimer.Stop() // the rate limit and the writing are not idle time
+		}
+
+		if n > 0 {
+			dwn.WaitRate(downloadCtx, seg.resource, n)
+			// the segment can be split during the download, the bytes past its end are left to the other half
+			written := n
+			if !seg.resource.isStreaming {
+				written = int(min(uint64(n), seg.RemainingLength()))
+			}
+			// the ack only covers the bytes on the file, the segment is retried from it
+			if _, err := seg.WriteAt(buf[:written], int64(seg.ack)); err != nil {
+				dwn.telemetry.Logger().Println("Download(*ResourceSegment) failed, status: WRITE_FAILED url:", seg.resource.url, "dest:", seg.resource.dest, "error:", err) // TODO telemetry
+				seg.FailDownload(ERR_WRITE)
+				return WRITE_FAILED
+			}
+			seg.Acknowledge(uint64(written))
+			dwn._received.Add(uint64(n))
+		}
+
+		if seg.IsSuperseded() {
+			dwn.telemetry.Logger().Println("Download(*ResourceSegment) cancelled, status: RANGE_MISMATCH url:", seg.resource.url) // TODO telemetry
+			seg.AbortDownload()
+			return RANGE_MISMATCH
+		}
+
+		if !seg.resource.isStreaming && seg.IsAcknowledged() {
+			dwn.telemetry.Logger().Println("Download(*ResourceSegment) break, status: READ_SUCCESS url:", seg.resource.url) // TODO telemetry
+			seg.FinishDownload()
+			return READ_SUCCESS
+		}
+
+		if !seg.IsSpeculationAlive() {
+			dwn.telemetry.Logger().Println("Download(*ResourceSegment) cancelled, status: SPECULATION_CANCELLED url:", seg.resource.url) // TODO telemetry
+			seg.CancelDownload()
+			return SPECULATION_CANCELLED
+		}
+
+		if seg.IsDoneBySpeculative() {
+			dwn.telemetry.Logger().Println("Download(*ResourceSegment) done by speculative download, status: READ_SUCCESS url:", seg.resource.url) // TODO telemetry
+			seg.AcknowledgeAll()
+			seg.FinishDownload()
+			return READ_SUCCESS
+		}
+
+		if err != nil && ctx.Err() != nil {
+			dwn.telemetry.Logger().Println("Download(*ResourceSegment) interrupted, status: INTERRUPTED url:", seg.resource.url) // TODO telemetry
+			seg.RequeueDownload()
+			return INTERRUPTED
+		}
+
+		if err != nil && isStalled.Load() {
+			dwn.telemetry.Logger().Println("Download(*ResourceSegment) stalled, status: STALLED url:", seg.resource.url, "ack:", seg.ack) // TODO telemetry
+			// the progress is lost without range, the segment would stall at the same point again
+			if seg.ack == startAck || seg.IsSpeculative() || !seg.resource.IsAcceptRange() {
+				seg.FailDownload(ERR_TIMEOUT)
+			} else {
+				seg.RequeueDownload()
+			}
+			return STALLED
+		}
+
+		if err == io.EOF && seg.resource.isStreaming {
+			dwn.telemetry.Logger().Println("Download(*ResourceSegment) end of stream, status: READ_SUCCESS url:", seg.resource.url, "length:", seg.ack) // TODO telemetry
+			if err := seg.resource.EndStreaming(seg.ack); err != nil {
+				dwn.telemetry.Logger().Println("Download(*ResourceSegment) failed to truncate file, dest:", seg.resource.dest, "error:", err) // TODO telemetry
+			}
+			seg.FinishDownload()
+			return READ_SUCCESS
+		}
+
+		// the body ends before the end of the segment
+		if err == io.EOF {
+			dwn.telemetry.Logger().Println("Download(*ResourceSegment) failed, status: READER_RETURNED_ERROR url:", seg.resource.url, "error: short read") // TODO telemetry
+			seg.FailDownload(ERR_SHORT_READ)
+			return READER_RETURNED_ERROR
+		}
+
+		if err != nil {
+			dwn.telemetry.Logger().Println("Download(*ResourceSegment) failed, status: READER_RETURNED_ERROR url:", seg.resource.url, "error:", err) // TODO telemetry
+			seg.FailDownload(ClassifyError(err))
+			return READER_RETURNED_ERROR
+		}
+
+		if stallTimer != nil {
+			stallTimer.Reset(dwn.idleRead)
+		}
+	}
+}
+
+type DownloaderCluster []*Downloader
+
+// Telemetry returns the telemetry shared by the downloaders of the cluster, nil if there is none.
+func (dc *DownloaderCluster) Telemetry() *Telemetry {
+	if len(*dc) == 0 {
+		return nil
+	}
+	return (*dc)[0].telemetry
+}
+
+/*
+FetchResourceRequests fetches the information of the requested resources with the downloaders in the cluster.
+
+If a resource can not be reached through a proxy, i.e. CONNECTION_TIMEOUT, CONNECTION_REFUSED or CONNECTION_FAILED,
+it is fetched again through up to PREFLIGHT_MAX_PROXIES different proxy IPs before it is declared unavailable.
+The fault of the resource request tells whether the proxy or the origin was at fault.
+
+Once the context is done, the remaining requests fail without retries, the caller is expected to check the context.
+*/
+func (dc *DownloaderCluster) FetchResourceRequests(ctx context.Context, userRequests []UserRequest) ResourceRequestList {
+	resourceRequests := make(ResourceRequestList, len(userRequests))
+
+	jobs := make([]func(worker *Downloader), len(userRequests))
+	for i, request := range userRequests {
+		handleI := i
+		handleRequest := request
+		jobs[i] = func(downloader *Downloader) {
+			rr := downloader.FetchResourceRequestWithMirrors(ctx, handleRequest)
+			if !IsConnectionFailure(rr.status) || ctx.Err() != nil {
+				resourceRequests[handleI] = rr
+				return
+			}
+
+			failedBy := []*Downloader{downloader}
+			for _, other := range dc.PreflightCandidates(downloader, handleI) {
+				orr := other.FetchResourceRequestWithMirrors(ctx, handleRequest)
+				if !IsConnectionFailure(orr.status) {
+					downloader.telemetry.Logger().Println("FetchResourceRequests() proxy at fault:", downloader.telemetry.GetDownloaderIp(downloader), "url:", handleRequest.url) // TODO telemetry
+					orr.failedBy = failedBy
+					orr.fault = PROXY_FAULT
+					resourceRequests[handleI] = orr
+					return
+				}
+				failedBy = append(failedBy, other)
+				if ctx.Err() != nil {
+					break
+				}
+			}
+
+			rr.failedBy = failedBy
+			if len(failedBy) == 1 {
+				rr.fault = UNKNOWN_FAULT
+			} else {
+				rr.fault = ORIGIN_FAULT
+			}
+			resourceRequests[handleI] = rr
+		}
+	}
+
+	ConsumeJobs(*dc, jobs)
+
+	return resourceRequests
+}
+
+// FetchResourceRequestWithMirrors tries the mirrors in order, the failure of the original url is reported if none of them is available.
+func (dwn *Downloader) FetchResourceRequestWithMirrors(ctx context.Context, request UserRequest) ResourceRequest {
+	rr := dwn.FetchResourceRequest(ctx, request)
+
+	for _, mirror := range request.mirrors {
+		if rr.status == AVAILABLE || ctx.Err() != nil {
+			break
+		}
+
+		mirrorRequest := request
+		mirrorRequest.url = mirror
+		if mrr := dwn.FetchResourceRequest(ctx, mirrorRequest); mrr.status == AVAILABLE {
+			dwn.telemetry.Logger().Println("FetchResourceRequests() use mirror:", mirror, "url:", request.url) // TODO telemetry
+			rr = mrr
+		}
+	}
+
+	return rr
+}
+
+/*
+PreflightCandidates returns the downloaders to retry the preflight of a resource which failed through the downloader,
+one per proxy IP other than the IP of the downloader, up to PREFLIGHT_MAX_PROXIES-1. The search starts at offset,
+so the retries of different resources are spread over the proxies. The tripped proxies are skipped.
+*/
+func (dc *DownloaderCluster) PreflightCandidates(dwn *Downloader, offset int) []*Downloader {
+	candidates := []*Downloader{}
+	triedIps := map[string]bool{dwn.ProxyIp(): true}
+
+	for i := 0; i < len(*dc) && len(candidates) < PREFLIGHT_MAX_PROXIES-1; i++ {
+		other := (*dc)[(offset+i)%len(*dc)]
+		if triedIps[other.ProxyIp()] || (other.proxy != nil && other.proxy.health.IsTripped()) {
+			continue
+		}
+		triedIps[other.ProxyIp()] = true
+		candidates = append(candidates, other)
+	}
+
+	return candidates
+}
+
+type DownloadOptions struct {
+	isEndgameEnabled bool             // race the slowest segments with the idle downloaders at the end of the download
+	scheduler        Scheduler        // LptScheduler if nil
+	tuner            *ConnectionTuner // all downloaders are used if nil
+}
+
+const ENDGAME_MIN_REMAINING_TIME = time.Second // the segments finishing sooner are not worth racing
+
+/*
+Download downloads the segments with the downloaders in the cluster until all segments are settled.
+
+A downloader is taken from the queue and assigned a pending segment, or a part of an in-progress segment split off
+for it if there is no pending segment, both chosen by the Scheduler in the options. If no downloader in the queue can
+be assigned anything, the scheduler sleeps until it is woken up by SchedulerEvents instead of polling.
+
+In the endgame, i.e. nothing is pending and no segment is worth splitting, an idle downloader races the slowest
+downloading segment with a speculative duplicate from its current ack if the endgame is enabled.
+*/
+func (dc *DownloaderCluster) Download(ctx context.Context, segments *[]*ResourceSegment, options DownloadOptions) {
+	scheduler := options.scheduler
+	if scheduler == nil {
+		scheduler = &LptScheduler{}
+	}
+	scheduler.Init(dc, *segments)
+
+	inFlightSegList := ThreadSafeQueue[ResourceSegment]{list: []*ResourceSegment{}}
+	downloaderQueue := make(chan *Downloader, len(*dc))
+	for _, downloader := range *dc {
+		putDownloader := downloader
+		downloaderQueue <- putDownloader
+	}
+
+	events := NewSchedulerEvents(*segments)
+	throttler := NewThrottler()
+	health := NewClusterHealth(dc)
+	telemetry := dc.Telemetry()
+
+	/*
+		Once all segments are settled, the downloads still in flight are the losers, i.e. the speculative duplicates and
+		the superseded downloads. They are cancelled with the tuner and the probes of the tripped proxies, and waited for
+		before returning, so nothing writes to the files or reports to the telemetry afterwards.
+	*/
+	clusterCtx, cancelCluster := context.WithCancel(ctx)
+	defer cancelCluster()
+
+	// every resource has its own context, so it can be cancelled without the others, see Resource.Cancel
+	isStarted := make(map[*Resource]bool)
+	for _, seg := range *segments {
+		if !isStarted[seg.resource] {
+			seg.resource.StartContext(clusterCtx)
+			isStarted[seg.resource] = true
+		}
+	}
+	wg := sync.WaitGroup{}
+	defer context.AfterFunc(ctx, events.Notify)()
+
+	if options.tuner != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			options.tuner.Run(downloaderQueue, events, clusterCtx.Done())
+		}()
+	}
+
+	// the number of downloaders in a row which could not be assigned anything since missVersion
+	misses := 0
+	missVersion := uint64(0)
+
+	for {
+		var dwn *Downloader
+
+		// stop handing out segments, the downloads in flight are cancelled and left pending
+		if ctx.Err() != nil {
+			telemetry.Logger().Println("Download([]*ResourceSegment) interrupted") // TODO telemetry
+			wg.Wait()
+			return
+		}
+
+		// break if all segments are downloaded or failed
+		select {
+		case <-events.AllSettled():
+			telemetry.Logger().Println("Download([]*ResourceSegment) finished") // TODO telemetry
+			cancelCluster()
+			wg.Wait()
+			return
+		case <-ctx.Done():
+			continue
+		case dwn = <-downloaderQueue:
+		}
+
+		// the proxy has been tripped since the downloader was put back to the queue
+		if dwn.proxy != nil && dwn.proxy.health.Bench(dwn) {
+			continue
+		}
+
+		// the proxy has more active downloaders than the tuned number of connections
+		if options.tuner != nil && options.tuner.Park(dwn) {
+			continue
+		}
+
+		version := events.Version()
+
+		isAllowed := func(seg *ResourceSegment) bool {
+			if dwn.limiter != nil && !dwn.limiter.IsAllowed(dwn, seg.resource.Host()) {
+				return false
+			}
+			return throttler.IsAllowed(dwn, seg.resource)
+		}
+
+		var seg *ResourceSegment = scheduler.Next(dwn, isAllowed)
+		if seg == nil {
+			if firstHalf, ratio := scheduler.NextSplit(dwn, isAllowed); firstHalf != nil {
+				secondHalf := firstHalf.Split(ratio)
+				telemetry.Logger().Println("Split url:", secondHalf.resource.url, "second from:", secondHalf.from, "to:", secondHalf.to) // TODO telemetry
+				*segments = append(*segments, secondHalf)
+				telemetry.ReportNewSegmentAdded(secondHalf)
+				events.ReportNewSegment()
+				seg = secondHalf
+			}
+		}
+
+		// a probe may have taken the connection since isAllowed, the segment waits for its next turn
+		if seg != nil && dwn.limiter != nil && !dwn.limiter.TryAcquire(dwn, seg.resource.Host()) {
+			telemetry.Logger().Println("Download([]*ResourceSegment) connection taken, url:", seg.resource.url) // TODO telemetry
+			scheduler.Push(seg)
+			events.Notify()
+			seg = nil
+		}
+
+		if seg == nil && options.isEndgameEnabled && scheduler.PendingLen() == 0 {
+			if original := dc.PickEndgameSegment(dwn, &inFlightSegList, isAllowed); original != nil && (dwn.limiter == nil || dwn.limiter.TryAcquire(dwn, original.resource.Host())) {
+				seg = original.Speculate()
+				if seg != nil {
+					telemetry.Logger().Println("Download([]*ResourceSegment) endgame, race url:", original.resource.url, "from:", seg.from, "to:", seg.to) // TODO telemetry
+				} else {
+					dwn.ReleaseConnection(original.resource.Host())
+				}
+			}
+		}
+
+		if seg == nil {
+			downloaderQueue <- dwn
+
+			if misses == 0 {
+				missVersion = version
+			}
+			misses++
+
+			// every idle downloader has been tried, nothing can be assigned until something happens
+			if misses >= len(downloaderQueue) {
+				telemetry.Logger().Println("Download([]*ResourceSegment) idle") // TODO telemetry
+
+				// the estimated remaining time and the back-offs change without any event, check them again later
+				wakeUp := throttler.NextExpiry()
+				if options.isEndgameEnabled && inFlightSegList.Len() != 0 && (wakeUp == 0 || wakeUp > ENDGAME_MIN_REMAINING_TIME) {
+					wakeUp = ENDGAME_MIN_REMAINING_TIME
+				}
+				if wakeUp != 0 {
+					timer := time.AfterFunc(wakeUp, events.Notify)
+					events.Wait(missVersion)
+					timer.Stop()
+				} else {
+					events.Wait(missVersion)
+				}
+				misses = 0
+			}
+			continue
+		}
+
+		misses = 0
+
+		scheduler.Started(dwn, seg)
+		if !seg.IsSpeculative() {
+			inFlightSegList.Push(seg)
+		}
+
+		wg.Add(1)
+		go func(dwn *Downloader, seg *ResourceSegment) {
+			defer wg.Done()
+			result := dwn.Download(seg.resource.Context(), seg)
+			scheduler.Finished(dwn, seg, result)
+			inFlightSegList.Remove(seg)
+			if options.tuner != nil {
+				options.tuner.ReportDownloadResult(dwn, result)
+			}
+			if dwn.limiter != nil {
+				// the segments waiting for the connection may be assigned now
+				dwn.ReleaseConnection(seg.resource.Host())
+				events.Notify()
+			}
+
+			if result == THROTTLED {
+				backoff := throttler.ReportThrottled(dwn, seg.resource, seg._retryAfter)
+				telemetry.Logger().Println("Download([]*ResourceSegment) throttled, url:", seg.resource.url, "back-off:", backoff) // TODO telemetry
+				telemetry.ReportThrottled(dwn)
+			} else if result == READ_SUCCESS {
+				throttler.ReportSuccess(dwn, seg.resource)
+			}
+			if result == STALLED {
+				telemetry.ReportStalled(dwn)
+			}
+
+			if seg.IsSpeculative() {
+				// the original segment is settled by its own download
+				telemetry.Logger().Println("Download([]*ResourceSegment) speculative download settled, url:", seg.resource.url, "from:", seg.from, "to:", seg.to, "result:", result) // TODO telemetry
+			} else if result == READ_SUCCESS {
+				telemetry.Logger().Println("Download([]*ResourceSegment) success, url:", seg.resource.url, "from:", seg.from, "to:", seg.to) // TODO telemetry
+				events.ReportSegmentSettled()
+			} else if result == RESOURCE_CHANGED {
+				telemetry.Logger().Println("Download([]*ResourceSegment) resource changed on server, url:", seg.resource.url, "from:", seg.from, "to:", seg.to) // TODO telemetry
+				events.ReportSegmentSettled()
+			} else if result == THROTTLED && seg.status == PENDING {
+				scheduler.Push(seg)
+				events.Notify()
+			} else if result == THROTTLED {
+				telemetry.Logger().Println("Download([]*ResourceSegment) throttled too many times, url:", seg.resource.url, "from:", seg.from, "to:", seg.to) // TODO telemetry
+				events.ReportSegmentSettled()
+			} else if result == RANGE_MISMATCH && seg.status == PENDING {
+				telemetry.Logger().Println("Download([]*ResourceSegment) downgraded to a single segment, url:", seg.resource.url) // TODO telemetry
+				scheduler.Push(seg)
+				events.Notify()
+			} else if result == RANGE_MISMATCH {
+				telemetry.Logger().Println("Download([]*ResourceSegment) superseded by the single segment, url:", seg.resource.url, "from:", seg.from, "to:", seg.to) // TODO telemetry
+				events.ReportSegmentSettled()
+			} else if result == STALLED && seg.status == PENDING {
+				telemetry.Logger().Println("Download([]*ResourceSegment) stalled, return to pending queue, url:", seg.resource.url, "from:", seg.from, "to:", seg.to, "ack:", seg.ack, "ttl:", seg.ttl) // TODO telemetry
+				scheduler.Push(seg)
+				events.Notify()
+			} else if result == STALLED {
+				telemetry.Logger().Println("Download([]*ResourceSegment) stalled, ttl = 0, url:", seg.resource.url, "from:", seg.from, "to:", seg.to) // TODO telemetry
+				events.ReportSegmentSettled()
+			} else if result == INTERRUPTED && seg.resource.IsCancelled() {
+				// left pending for a later run, like an interrupted download
+				telemetry.Logger().Println("Download([]*ResourceSegment) resource cancelled, url:", seg.resource.url, "from:", seg.from, "to:", seg.to, "ack:", seg.ack) // TODO telemetry
+				events.ReportSegmentSettled()
+			} else if result == INTERRUPTED {
+				telemetry.Logger().Println("Download([]*ResourceSegment) interrupted, url:", seg.resource.url, "from:", seg.from, "to:", seg.to, "ack:", seg.ack) // TODO telemetry
+			} else {
+				if IsProxyFailure(result) {
+					seg.ReportFailedBy(dwn)
+				}
+				if seg.ttl > 0 {
+					telemetry.Logger().Println("Download([]*ResourceSegment) return to pending queue, url:", seg.resource.url, "from:", seg.from, "to:", seg.to, "ttl:", seg.ttl) // TODO telemetry
+					scheduler.Push(seg)
+					events.Notify()
+				} else {
+					telemetry.Logger().Println("Download([]*ResourceSegment) ttl = 0, url:", seg.resource.url, "from:", seg.from, "to:", seg.to) // TODO telemetry
+					events.ReportSegmentSettled()
+				}
+			}
+
+			if health.ReportDownloadResult(dwn, result) {
+				telemetry.Logger().Println("Download([]*ResourceSegment) proxy tripped:", dwn.proxy.Address()) // TODO telemetry
+				dwn.proxy.health.Bench(dwn)
+				events.Notify()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					MonitorTrippedProxy(clusterCtx, dwn, downloaderQueue, events)
+				}()
+				return
+			}
+			if dwn.proxy != nil && dwn.proxy.health.Bench(dwn) {
+				return
+			}
+			downloaderQueue <- dwn
+		}(dwn, seg)
+	}
+}
+
+/*
+PickEndgameSegment picks the downloading segment with the longest estimated remaining time for the downloader to race.
+The segments downloaded through the same proxy IP as the downloader are not picked, the race would share the bandwidth.
+It returns nil if there is no segment worth racing.
+*/
+func (dc *DownloaderCluster) PickEndgameSegment(dwn *Downloader, inFlightSegList *ThreadSafeQueue[ResourceSegment], isAllowed func(seg *ResourceSegment) bool) *ResourceSegment {
+	var slowest *ResourceSegment = nil
+	slowestRemainingTime := ENDGAME_MIN_REMAINING_TIME
+
+	for _, seg := range inFlightSegList.Items() {
+		if !seg.resource.IsAcceptRange() || !seg.IsDownloading() || !isAllowed(seg) {
+			continue
+		}
+		if other := seg.Downloader(); other != nil && other.proxy != nil && dwn.proxy != nil && other.proxy.Ip() == dwn.proxy.Ip() {
+			continue
+		}
+
+		if remainingTime := seg.EstimatedRemainingTime(); remainingTime > slowestRemainingTime {
+			slowest = seg
+			slowestRemainingTime = remainingTime
+		}
+	}
+
+	return slowest
+}
+
+/*
+PopPendingSegment picks an allowed pending segment for the downloader, preferring the segments which have not failed
+through the downloader or its proxy IP. A segment which has failed through the downloader is only picked if no other
+downloader in the cluster is better for the retry. It returns nil if there is no segment to pick.
+*/
+func (dc *DownloaderCluster) PopPendingSegment(dwn *Downloader, pendingSegQueue *ThreadSafeQueue[ResourceSegment], isAllowed func(seg *ResourceSegment) bool) *ResourceSegment {
+	if seg := pendingSegQueue.PopFunc(func(seg *ResourceSegment) bool {
+		return seg.FailureScore(dwn) == 0 && isAllowed(seg)
+	}); seg != nil {
+		return seg
+	}
+
+	return pendingSegQueue.PopFunc(func(seg *ResourceSegment) bool {
+		if !isAllowed(seg) {
+			return false
+		}
+		score := seg.FailureScore(dwn)
+		for _, other := range *dc {
+			if other.proxy != nil && other.proxy.health.IsTripped() {
+				continue
+			}
+			if seg.FailureScore(other) < score {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+type IpList []string
+
+/*
+ToDownloaderCluster creates numOfConn downloaders with the timeouts over the proxies in the list (see ParseProxy for the format),
+they report to the telemetry, which may be nil.
+The connections are assigned round by round, each proxy gets as many connections as its weight in a round until it
+reaches its max-connections. Fewer downloaders are created if all proxies reach their max-connections.
+An error is returned if the list is empty, the number of connections is invalid or any line is not a valid proxy.
+*/
+func (ipList *IpList) ToDownloaderCluster(numOfConn int, timeouts *Timeouts, telemetry *Telemetry) (DownloaderCluster, error) {
+	if len(*ipList) == 0 || numOfConn <= 0 {
+		return nil, fmt.Errorf("no proxy server or invalid number of connections provided")
+	}
+
+	proxies := []*Proxy{}
+	for _, line := range *ipList {
+		proxy, err := ParseProxy(line)
+		if err != nil {
+			return nil, fmt.Errorf("error due to parsing proxy: %s (%v)", line, err)
+		}
+		proxies = append(proxies, proxy)
+	}
+
+	var downloaders []*Downloader
+	var i = numOfConn
+	connCountMap := make(map[*Proxy]int)
+
+	for {
+		isAnyAssigned := false
+		for _, proxy := range proxies {
+			for w := 0; w < proxy.weight; w++ {
+				if proxy.maxConnections != 0 && connCountMap[proxy] >= proxy.maxConnections {
+					break
+				}
+
+				downloaders = append(downloaders, ConstructDownloaderFromProxy(proxy, timeouts, telemetry))
+				connCountMap[proxy]++
+				isAnyAssigned = true
+				i--
+
+				if i == 0 {
+					return downloaders, nil
+				}
+			}
+		}
+
+		if !isAnyAssigned {
+			telemetry.Logger().Println("ToDownloaderCluster() all proxies reached max-connections, number of downloaders:", len(downloaders)) // TODO telemetry
+			return downloaders, nil
+		}
+	}
+}
+
+type OriginalUserRequestList []string
+
+/*
+ToUserRequests parses every line of the request list, either in the arrow syntax ('url > dest # checksum')
+or as a JSON object of the manifest format (see ManifestEntry). Both formats can be mixed in the same list.
+An error is returned for the first line which can not be parsed.
+*/
+func (ourList *OriginalUserRequestList) ToUserRequests() ([]UserRequest, error) {
+	var userRequests []UserRequest
+	for _, request := range *ourList {
+		if IsManifestLine(request) {
+			userRequest, err := ParseManifestLine(request)
+			if err != nil {
+				return nil, err
+			}
+			userRequests = append(userRequests, userRequest)
+			continue
+		}
+
+		rawUrl := ""
+		rawDest := ""
+		rawRequest := request
+		checksum := Checksum{}
+		if strings.Contains(rawRequest, " # ") {
+			idx := strings.LastIndex(rawRequest, " # ")
+			parsed, err := ParseChecksum(rawRequest[idx+3:])
+			if err != nil {
+				return nil, fmt.Errorf("error due to parsing checksum: %s (%v)", request, err)
+			}
+			checksum = parsed
+			rawRequest = rawRequest[:idx]
+		}
+
+		if strings.Contains(rawRequest, " > ") {
+			split := strings.Split(rawRequest, " > ")
+
+			rawUrl = strings.TrimSpace(split[0])
+			rawDest = strings.TrimSpace(split[1])
+		} else {
+			rawUrl = strings.TrimSpace(rawRequest)
+		}
+
+		url, err := ParseRequestUrl(rawUrl, request)
+		if err != nil {
+			return nil, err
+		}
+		dest, err := ResolveRequestDest(url, rawDest)
+		if err != nil {
+			return nil, err
+		}
+
+		userRequests = append(userRequests, UserRequest{url: url, dest: dest, checksum: checksum})
+	}
+
+	return userRequests, nil
+}
+
+func ParseRequestUrl(rawUrl string, request string) (string, error) {
+	rawUrlWithoutFragment, _, _ := strings.Cut(rawUrl, "#")
+	urlObj, err := url.ParseRequestURI(rawUrlWithoutFragment)
+	if err != nil {
+		return "", fmt.Errorf("error due to parsing url: %s", request)
+	}
+	return urlObj.String(), nil
+}
+
+/*
+ResolveRequestDest returns the file path to save the resource of the url to.
+If rawDest is an existing directory (or empty for the current directory), the file name in the url is used.
+Otherwise, rawDest is used as the file path and the parent directories are created if necessary.
+*/
+func ResolveRequestDest(url string, rawDest string) (string, error) {
+	rawDest, _ = filepath.Abs(rawDest)
+	urlFileName := path.Base(url)
+
+	dest := ""
+	info1, err2 := os.Stat(rawDest)
+	if err2 == nil && !info1.IsDir() {
+		dest = rawDest // overwrite the destination
+	} else if err2 == nil && info1.IsDir() {
+		dest = path.Join(rawDest, urlFileName)
+	} else {
+		rawDestParent := path.Dir(rawDest)
+		err3 := os.MkdirAll(rawDestParent, os.ModePerm)
+		if err3 != nil {
+			return "", fmt.Errorf("error due to creating directory: %s (%v)", rawDestParent, err3)
+		}
+		dest = rawDest
+	}
+
+	return dest, nil
+}
+
+type ResourceRequestList []ResourceRequest
+
+func (rrl *ResourceRequestList) TotalContentLength() uint64 {
+	totalSize := uint64(0) // in bytes
+	for _, request := range *rrl {
+		totalSize += request.contentLength
+	}
+
+	return totalSize
+}
+
+/*
+ToResources creates the resources and splits them into segments with the given chunk size.
+If resume is true, the segments are rebuilt from the journal next to the destination whenever a usable one exists.
+*/
+func (rrl *ResourceRequestList) ToResources(chunkSize uint64, resume bool, retryPolicy *RetryPolicy) []*Resource {
+	var resources []*Resource
+	for _, request := range *rrl {
+		resource := request.ToResource(retryPolicy)
+
+		resources = append(resources, resource)
+		if resume && resource.LoadJournal() {
+			resource.telemetry.Logger().Println("ToResources() resumed from journal, dest:", resource.dest) // TODO telemetry
+		} else {
+			resource.SliceSegments(chunkSize)
+		}
+	}
+
+	return resources
+}
+
+/*
+ToResource creates the resource without any segment, the segments are attempted according to the retry policy.
+The resource reports to the telemetry of the downloader which fetched the request.
+*/
+func (request *ResourceRequest) ToResource(retryPolicy *RetryPolicy) *Resource {
+	var rateLimit *TokenBucket = nil
+	if request.rateLimit != 0 {
+		rateLimit = NewTokenBucket(request.rateLimit)
+	}
+
+	var telemetry *Telemetry = nil
+	if request.fetchedBy != nil {
+		telemetry = request.fetchedBy.telemetry
+	}
+
+	return &Resource{
+		url:              request.url,
+		dest:             request.dest,
+		contentLength:    request.contentLength,
+		isAcceptRange:    request.isAcceptRange,
+		isStreaming:      request.isStreaming,
+		etag:             request.etag,
+		lastModified:     request.lastModified,
+		checksum:         request.checksum,
+		headers:          request.headers,
+		priority:         request.priority,
+		rateLimit:        rateLimit,
+		retryPolicy:      retryPolicy,
+		telemetry:        telemetry,
+		_fd:              nil,
+		_segments:        []*ResourceSegment{},
+		_writtenSegments: []*ResourceSegment{}}
+}
+
+func ConstructDownloaderFromProxy(proxy *Proxy, timeouts *Timeouts, telemetry *Telemetry) *Downloader {
+	var client DownloaderClient
+
+	if proxy.url.Scheme == "socks5" {
+		client = NewSocks5DownloaderClient(proxy.url, timeouts)
+	} else {
+		transport := timeouts.NewTransport()
+		transport.Proxy = http.ProxyURL(proxy.url) // set proxy, the credentials are sent in Proxy-Authorization
+
+		httpClient := &DownloaderClientImpl{}
+		httpClient.Transport = transport
+		client = httpClient
+	}
+
+	dwn := &Downloader{client: client, proxy: proxy, idleRead: timeouts.idleRead, telemetry: telemetry}
+
+	telemetry.ReportNewDownloaderAdded(dwn, proxy.Address())
+
+	return dwn
+}
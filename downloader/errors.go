@@ -0,0 +1,184 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type ErrorClass int
+
+const (
+	ERR_DNS ErrorClass = iota
+	ERR_DIAL_TIMEOUT
+	ERR_CONNECTION_REFUSED
+	ERR_UNREACHABLE
+	ERR_TLS
+	ERR_RESET
+	ERR_TIMEOUT
+	ERR_SHORT_READ
+	ERR_STATUS_4XX
+	ERR_STATUS_5XX
+	ERR_THROTTLED // 429 or 503, retried without losing ttl, see DEFAULT_THROTTLED_RETRIES
+	ERR_WRITE     // the destination file can not be written, not the fault of the proxy
+	ERR_OTHER
+)
+
+var errorClassNames = map[ErrorClass]string{
+	ERR_DNS:                "dns",
+	ERR_DIAL_TIMEOUT:       "dial-timeout",
+	ERR_CONNECTION_REFUSED: "refused",
+	ERR_UNREACHABLE:        "unreachable",
+	ERR_TLS:                "tls",
+	ERR_RESET:              "reset",
+	ERR_TIMEOUT:            "timeout",
+	ERR_SHORT_READ:         "short-read",
+	ERR_STATUS_4XX:         "status-4xx",
+	ERR_STATUS_5XX:         "status-5xx",
+	ERR_THROTTLED:          "throttled",
+	ERR_WRITE:              "write",
+	ERR_OTHER:              "other",
+}
+
+func (class ErrorClass) String() string {
+	return errorClassNames[class]
+}
+
+func ParseErrorClass(name string) (ErrorClass, bool) {
+	for class, className := range errorClassNames {
+		if className == name {
+			return class, true
+		}
+	}
+	return ERR_OTHER, false
+}
+
+/*
+ClassifyError tells the kind of failure from the error returned by the client or the reader of the response body.
+The errors of the SOCKS5 proxies are classified like the direct connections, see Socks5Dialer.
+*/
+func ClassifyError(err error) ErrorClass {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var netErr net.Error
+	var recordHeaderErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalidErr x509.CertificateInvalidError
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return ERR_DNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ERR_CONNECTION_REFUSED
+	case errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH):
+		return ERR_UNREACHABLE
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNABORTED):
+		return ERR_RESET
+	case errors.As(err, &recordHeaderErr) || errors.As(err, &alertErr) || errors.As(err, &certErr) ||
+		errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &certInvalidErr):
+		return ERR_TLS
+	case errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout():
+		return ERR_DIAL_TIMEOUT
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ERR_TIMEOUT
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		return ERR_SHORT_READ
+	default:
+		return ERR_OTHER
+	}
+}
+
+// ClassifyStatusCode tells the kind of failure from a status code which is neither 2xx nor throttling.
+func ClassifyStatusCode(statusCode int) ErrorClass {
+	switch {
+	case statusCode >= 400 && statusCode < 500:
+		return ERR_STATUS_4XX
+	case statusCode >= 500 && statusCode < 600:
+		return ERR_STATUS_5XX
+	default:
+		return ERR_OTHER
+	}
+}
+
+const DEFAULT_SEGMENT_TTL = 3
+const DEFAULT_THROTTLED_RETRIES = 10 // the throttled downloads do not take ttl, they are limited on their own
+
+/*
+RetryPolicy decides how many times a segment is attempted. Every segment has ttl attempts in total, and a class
+can be limited to fewer retries, e.g. 'status-4xx=0' gives up a segment on the first 4xx response while the other
+failures are still retried.
+*/
+type RetryPolicy struct {
+	ttl     uint8              // the number of attempts of a new segment
+	retries map[ErrorClass]int // the maximum number of retries after the failures of the class, only ttl applies if not set
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{ttl: DEFAULT_SEGMENT_TTL, retries: map[ErrorClass]int{ERR_THROTTLED: DEFAULT_THROTTLED_RETRIES}}
+}
+
+/*
+ParseRetryPolicy parses a comma separated list of 'key=value', where the key is ttl or an error class, e.g.
+'ttl=5,dns=0,status-4xx=1'. The error classes are dns, dial-timeout, refused, unreachable, tls, reset, timeout,
+short-read, status-4xx, status-5xx, throttled, write and other. The throttled downloads do not take ttl, so they
+are always limited, by DEFAULT_THROTTLED_RETRIES unless given.
+*/
+func ParseRetryPolicy(raw string) (*RetryPolicy, error) {
+	policy := DefaultRetryPolicy()
+	for _, attr := range strings.Split(raw, ",") {
+		attr = strings.TrimSpace(attr)
+		if attr == "" {
+			continue
+		}
+
+		key, rawValue, found := strings.Cut(attr, "=")
+		if !found {
+			return nil, fmt.Errorf("the retry policy must be in the format of 'key=value': %s", attr)
+		}
+		value, err := strconv.Atoi(rawValue)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("the value of %s must be a non-negative integer: %s", key, rawValue)
+		}
+
+		if key == "ttl" {
+			if value == 0 || value > 255 {
+				return nil, fmt.Errorf("the ttl must be between 1 and 255: %s", rawValue)
+			}
+			policy.ttl = uint8(value)
+			continue
+		}
+
+		class, ok := ParseErrorClass(key)
+		if !ok {
+			return nil, fmt.Errorf("unknown error class: %s", key)
+		}
+		policy.retries[class] = value
+	}
+	return policy, nil
+}
+
+// Ttl returns the number of attempts of a new segment, a nil policy is the default policy.
+func (p *RetryPolicy) Ttl() uint8 {
+	if p == nil {
+		return DEFAULT_SEGMENT_TTL
+	}
+	return p.ttl
+}
+
+// ShouldRetry returns false if the segment has failed more times than the retries of the class, a nil policy is the default policy.
+func (p *RetryPolicy) ShouldRetry(class ErrorClass, failures int) bool {
+	if p == nil {
+		p = DefaultRetryPolicy()
+	}
+	retries, ok := p.retries[class]
+	return !ok || failures <= retries
+}
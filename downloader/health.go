@@ -0,0 +1,195 @@
+package downloader
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const CIRCUIT_BREAKER_THRESHOLD = 3 // the number of consecutive failures to trip the circuit breaker of a proxy
+const CIRCUIT_BREAKER_MIN_BACKOFF = 2 * time.Second
+const CIRCUIT_BREAKER_MAX_BACKOFF = time.Minute
+const HEALTH_PROBE_TIMEOUT = 5 * time.Second
+
+/*
+ProxyHealth is the circuit breaker of a proxy, shared by all downloaders of the proxy.
+
+The circuit breaker is tripped after CIRCUIT_BREAKER_THRESHOLD consecutive failures. The downloaders of a tripped
+proxy are benched, i.e. removed from the downloader queue, until a health probe of the proxy succeeds.
+The probe is sent after a back-off which doubles on every failed probe, from CIRCUIT_BREAKER_MIN_BACKOFF up to
+CIRCUIT_BREAKER_MAX_BACKOFF.
+*/
+type ProxyHealth struct {
+	mutex               sync.Mutex
+	consecutiveFailures int
+	isTripped           bool
+	backoff             time.Duration
+	tripCount           int
+	benched             []*Downloader
+}
+
+// IsProxyFailure returns true if the proxy may be at fault, a status code is the answer of the origin server.
+func IsProxyFailure(result DownloadResult) bool {
+	return result == CLIENT_RETURNED_ERROR || result == READER_RETURNED_ERROR
+}
+
+func (h *ProxyHealth) IsTripped() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.isTripped
+}
+
+func (h *ProxyHealth) TripCount() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.tripCount
+}
+
+func (h *ProxyHealth) ReportSuccess() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.consecutiveFailures = 0
+}
+
+// ReportFailure returns true if the failure trips the circuit breaker.
+func (h *ProxyHealth) ReportFailure(canTrip bool) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.consecutiveFailures++
+	if h.isTripped || !canTrip || h.consecutiveFailures < CIRCUIT_BREAKER_THRESHOLD {
+		return false
+	}
+
+	h.isTripped = true
+	h.backoff = CIRCUIT_BREAKER_MIN_BACKOFF
+	h.tripCount++
+	return true
+}
+
+// Bench keeps the downloader aside until the proxy is healthy again, it returns false if the proxy is not tripped.
+func (h *ProxyHealth) Bench(dwn *Downloader) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.isTripped {
+		return false
+	}
+
+	h.benched = append(h.benched, dwn)
+	return true
+}
+
+// Readmit closes the circuit breaker and returns the benched downloaders.
+func (h *ProxyHealth) Readmit() []*Downloader {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	benched := h.benched
+	h.benched = nil
+	h.isTripped = false
+	h.consecutiveFailures = 0
+	return benched
+}
+
+func (h *ProxyHealth) NextBackoff() time.Duration {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	backoff := h.backoff
+	h.backoff = min(h.backoff*2, CIRCUIT_BREAKER_MAX_BACKOFF)
+	return backoff
+}
+
+/*
+Probe checks whether the proxy accepts connections. It does not request any resource, a failing origin server would
+keep a healthy proxy benched.
+*/
+func (p *Proxy) Probe(ctx context.Context) bool {
+	probeCtx, cancel := context.WithTimeout(ctx, HEALTH_PROBE_TIMEOUT)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(probeCtx, "tcp", p.Address())
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// ClusterHealth trips the circuit breakers of the proxies of a cluster, see ReportDownloadResult.
+type ClusterHealth struct {
+	mutex sync.Mutex // the check of the last healthy proxy and the trip are atomic across the cluster
+	dc    *DownloaderCluster
+}
+
+func NewClusterHealth(dc *DownloaderCluster) *ClusterHealth {
+	return &ClusterHealth{dc: dc}
+}
+
+/*
+ReportDownloadResult updates the health of the proxy of the downloader and returns true if the proxy is tripped by
+the result. The last healthy proxy of the cluster is never tripped, otherwise nothing could download anymore and
+the segments are left to fail by their ttl instead.
+*/
+func (ch *ClusterHealth) ReportDownloadResult(dwn *Downloader, result DownloadResult) bool {
+	if dwn.proxy == nil {
+		return false
+	}
+
+	if result == READ_SUCCESS {
+		dwn.proxy.health.ReportSuccess()
+		return false
+	}
+
+	if !IsProxyFailure(result) {
+		return false
+	}
+
+	// the concurrent failures of the other proxies could trip them all between the check and the trip
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	canTrip := false
+	for _, other := range *ch.dc {
+		if other.proxy != nil && other.proxy != dwn.proxy && !other.proxy.health.IsTripped() {
+			canTrip = true
+			break
+		}
+	}
+
+	return dwn.proxy.health.ReportFailure(canTrip)
+}
+
+/*
+MonitorTrippedProxy probes the tripped proxy of the downloader with exponential back-off until it is healthy again
+or the context is done, then puts the benched downloaders of the proxy back to the downloader queue.
+*/
+func MonitorTrippedProxy(ctx context.Context, dwn *Downloader, downloaderQueue chan *Downloader, events *SchedulerEvents) {
+	proxy := dwn.proxy
+	for {
+		backoff := proxy.health.NextBackoff()
+		dwn.telemetry.Logger().Println("MonitorTrippedProxy() proxy:", proxy.Address(), "back-off:", backoff) // TODO telemetry
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if proxy.Probe(ctx) {
+			break
+		}
+	}
+
+	benched := proxy.health.Readmit()
+	dwn.telemetry.Logger().Println("MonitorTrippedProxy() proxy readmitted:", proxy.Address(), "downloaders:", len(benched)) // TODO telemetry
+	for _, benchedDwn := range benched {
+		downloaderQueue <- benchedDwn
+	}
+	events.Notify()
+}
@@ -0,0 +1,255 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+type ResourceSegmentJournal struct {
+	From   uint64         `json:"from"`
+	To     uint64         `json:"to"`
+	Ack    uint64         `json:"ack"`
+	Ttl    uint8          `json:"ttl"`
+	Status ResourceStatus `json:"status"`
+}
+
+type ResourceJournal struct {
+	Url           string                   `json:"url"`
+	ContentLength uint64                   `json:"contentLength"`
+	IsAcceptRange bool                     `json:"isAcceptRange"`
+	ETag          string                   `json:"etag"`
+	LastModified  string                   `json:"lastModified"`
+	Segments      []ResourceSegmentJournal `json:"segments"`
+}
+
+func JournalPath(dest string) string {
+	return dest + ".journal"
+}
+
+func (r *Resource) ToJournal() ResourceJournal {
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	journal := ResourceJournal{
+		Url:           r.url,
+		ContentLength: r.contentLength,
+		IsAcceptRange: r.isAcceptRange,
+		ETag:          r.etag,
+		LastModified:  r.lastModified,
+		Segments:      []ResourceSegmentJournal{}}
+
+	for _, rs := range r._writtenSegments {
+		journal.Segments = append(journal.Segments, rs.ToJournal())
+	}
+	for _, rs := range r._segments {
+		journal.Segments = append(journal.Segments, rs.ToJournal())
+	}
+
+	return journal
+}
+
+func (rs *ResourceSegment) ToJournal() ResourceSegmentJournal {
+	return ResourceSegmentJournal{
+		From:   rs.from,
+		To:     rs.to,
+		Ack:    rs.ack,
+		Ttl:    rs.ttl,
+		Status: rs.status}
+}
+
+/*
+SaveJournal writes the progress of all segments to the journal file next to the destination.
+
+The progress is taken before the file is synced, and the ack of a segment only advances after the bytes are written,
+so the acknowledged ranges in the journal are always on disk. The journal is written to a temporary file first, synced
+and then renamed, so a crash never leaves a truncated journal.
+*/
+func (r *Resource) SaveJournal() error {
+	return r.saveJournal(false)
+}
+
+// CheckpointJournal is SaveJournal, but nothing is synced or written if the progress has not changed since the last save.
+func (r *Resource) CheckpointJournal() error {
+	return r.saveJournal(true)
+}
+
+func (r *Resource) saveJournal(isChangedOnly bool) error {
+	r._journalMutex.Lock()
+	defer r._journalMutex.Unlock()
+
+	// The journal is removed once the resource is completed or changed, don't bring it back
+	if r.IsCompleted() || r.IsChanged() {
+		return nil
+	}
+
+	// the progress is taken before the file is synced
+	data, err := json.Marshal(r.ToJournal())
+	if err != nil {
+		return err
+	}
+
+	if isChangedOnly && bytes.Equal(data, r._journal) {
+		return nil
+	}
+
+	if r._fd != nil {
+		if err := r._fd.Sync(); err != nil {
+			return err
+		}
+	}
+
+	path := JournalPath(r.dest)
+	tmpPath := path + ".tmp"
+	if err := writeFileSync(tmpPath, data); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	r._journal = data
+	return nil
+}
+
+// writeFileSync is os.WriteFile which syncs the file before closing it.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (r *Resource) RemoveJournal() error {
+	r._journalMutex.Lock()
+	defer r._journalMutex.Unlock()
+
+	r._journal = nil
+	err := os.Remove(JournalPath(r.dest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+/*
+LoadJournal rebuilds the segments of the resource from the journal file next to the destination.
+
+It returns false if there is no usable journal, e.g. the journal does not exist, the destination file is missing,
+the journal was written for a different url, content length or version (ETag / Last-Modified) of the resource,
+or the resource is streaming, i.e. the length is unknown.
+In that case the resource is left untouched and should be downloaded from scratch.
+*/
+func (r *Resource) LoadJournal() bool {
+	if r.isStreaming {
+		return false
+	}
+
+	data, err := os.ReadFile(JournalPath(r.dest))
+	if err != nil {
+		return false
+	}
+
+	if _, err := os.Stat(r.dest); err != nil {
+		return false
+	}
+
+	journal := ResourceJournal{}
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return false
+	}
+
+	if journal.Url != r.url || journal.ContentLength != r.contentLength || journal.IsAcceptRange != r.isAcceptRange {
+		return false
+	}
+
+	if journal.ETag != r.etag || journal.LastModified != r.lastModified {
+		r.telemetry.Logger().Println("LoadJournal() the resource has changed on the server since the journal was written, dest:", r.dest) // TODO telemetry
+		return false
+	}
+
+	segments := []*ResourceSegment{}
+	writtenSegments := []*ResourceSegment{}
+	for _, sj := range journal.Segments {
+		if sj.From > sj.To || sj.To > r.contentLength || sj.Ack < sj.From || sj.Ack > sj.To {
+			return false
+		}
+
+		rs := &ResourceSegment{resource: r, from: sj.From, to: sj.To, ack: sj.Ack, ttl: sj.Ttl, status: sj.Status}
+		if rs.status == DOWNLOADED {
+			writtenSegments = append(writtenSegments, rs)
+			continue
+		}
+
+		// Segments that were downloading or failed in the previous run are given another chance
+		rs.status = PENDING
+		rs.ttl = r.retryPolicy.Ttl()
+		if !r.isAcceptRange {
+			rs.ack = rs.from
+		}
+		segments = append(segments, rs)
+	}
+
+	r._segments = segments
+	r._writtenSegments = writtenSegments
+	return true
+}
+
+/*
+CheckpointResources saves the journal of every resource periodically until the returned function is called.
+The ack of a segment moves on every read, so the journal is not written on every change but every interval. The
+resources without progress since the last save are skipped, see CheckpointJournal.
+*/
+func CheckpointResources(resources []*Resource, interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, r := range resources {
+					if err := r.CheckpointJournal(); err != nil {
+						r.telemetry.Logger().Println("CheckpointResources() failed to save journal, dest:", r.dest, "error:", err) // TODO telemetry
+					}
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// SuspendResources saves the journals and closes the files of the unfinished resources, e.g. after an interrupt.
+func SuspendResources(resources []*Resource) {
+	for _, r := range resources {
+		if err := r.SaveJournal(); err != nil {
+			r.telemetry.Logger().Println("SuspendResources() failed to save journal, dest:", r.dest, "error:", err) // TODO telemetry
+		}
+		if err := r.CloseFile(); err != nil {
+			r.telemetry.Logger().Println("SuspendResources() failed to close file, dest:", r.dest, "error:", err) // TODO telemetry
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+)
+
+type ConnectionKey struct {
+	ip   string // the proxy IP, empty for the downloaders without proxy
+	host string // the origin host
+}
+
+/*
+ConnectionLimiter limits the number of concurrent connections from a proxy IP to an origin host, e.g. to respect the
+per-IP connection limit of a server. The limit is the max-connections-per-host attribute of the proxy, or
+maxPerHost if not set. It is separate from the total number of connections, the downloaders are not assigned the
+segments of an origin host at the limit and download the other resources meanwhile. The other requests, i.e. the
+preflight and the throughput probes, wait for a connection instead, see Downloader.AcquireConnection.
+
+The downloaders of different ports of the same proxy IP share the limit, the server sees the same IP.
+*/
+type ConnectionLimiter struct {
+	mutex      sync.Mutex
+	maxPerHost int // 0 means unlimited
+	active     map[ConnectionKey]int
+	released   chan struct{} // closed and replaced on every release, wakes up the waiting requests
+}
+
+func NewConnectionLimiter(maxPerHost int) *ConnectionLimiter {
+	return &ConnectionLimiter{maxPerHost: maxPerHost, active: make(map[ConnectionKey]int), released: make(chan struct{})}
+}
+
+// LimitConnections limits the connections of all downloaders in the cluster, see ConnectionLimiter.
+func (dc *DownloaderCluster) LimitConnections(maxPerHost int) {
+	limiter := NewConnectionLimiter(maxPerHost)
+	for _, dwn := range *dc {
+		dwn.limiter = limiter
+	}
+}
+
+func ToConnectionKey(dwn *Downloader, host string) ConnectionKey {
+	return ConnectionKey{ip: dwn.ProxyIp(), host: host}
+}
+
+// Limit returns the maximum number of concurrent connections from the proxy IP of the downloader to an origin host.
+func (cl *ConnectionLimiter) Limit(dwn *Downloader) int {
+	if dwn.proxy != nil && dwn.proxy.maxConnectionsPerHost != 0 {
+		return dwn.proxy.maxConnectionsPerHost
+	}
+	return cl.maxPerHost
+}
+
+// IsAllowed returns false if the downloader can not open one more connection to the origin host, see Resource.Host.
+func (cl *ConnectionLimiter) IsAllowed(dwn *Downloader, host string) bool {
+	limit := cl.Limit(dwn)
+	if limit == 0 {
+		return true
+	}
+
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	return cl.active[ToConnectionKey(dwn, host)] < limit
+}
+
+/*
+TryAcquire acquires a connection if the downloader is allowed one more connection to the origin host, and returns false
+otherwise. The check and the acquisition are under the same lock, the connection may be taken by a request waiting in
+Wait right after IsAllowed.
+*/
+func (cl *ConnectionLimiter) TryAcquire(dwn *Downloader, host string) bool {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	return cl.tryAcquire(dwn, host)
+}
+
+// tryAcquire is TryAcquire with the lock held.
+func (cl *ConnectionLimiter) tryAcquire(dwn *Downloader, host string) bool {
+	limit := cl.Limit(dwn)
+	key := ToConnectionKey(dwn, host)
+	if limit != 0 && cl.active[key] >= limit {
+		return false
+	}
+
+	cl.active[key]++
+	return true
+}
+
+// Wait acquires a connection once the downloader is allowed one more connection to the origin host, or fails once the context is done.
+func (cl *ConnectionLimiter) Wait(ctx context.Context, dwn *Downloader, host string) error {
+	for {
+		cl.mutex.Lock()
+		if cl.tryAcquire(dwn, host) {
+			cl.mutex.Unlock()
+			return nil
+		}
+		released := cl.released
+		cl.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+func (cl *ConnectionLimiter) Release(dwn *Downloader, host string) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	key := ToConnectionKey(dwn, host)
+	cl.active[key]--
+	if cl.active[key] <= 0 {
+		delete(cl.active, key)
+	}
+
+	close(cl.released)
+	cl.released = make(chan struct{})
+}
+
+// AcquireConnection waits for a connection to the origin host if the downloader is limited, see ReleaseConnection.
+func (dwn *Downloader) AcquireConnection(ctx context.Context, host string) error {
+	if dwn.limiter == nil {
+		return nil
+	}
+	return dwn.limiter.Wait(ctx, dwn, host)
+}
+
+func (dwn *Downloader) ReleaseConnection(host string) {
+	if dwn.limiter != nil {
+		dwn.limiter.Release(dwn, host)
+	}
+}
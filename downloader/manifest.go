@@ -0,0 +1,103 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+ManifestEntry is a line of the request list in the JSON format, e.g.
+
+	{"url": "http://example.com/file.zip", "dest": "/path/to/save/", "checksum": "sha256:e3b0c442...", "priority": 1}
+
+Only url is required, the other fields are optional:
+  - dest: same as the destination in the arrow syntax
+  - headers: extra request headers sent with every request of the resource
+  - expectedSize: the expected content length in bytes, the resource is not downloaded if the server reports another size
+  - checksum: same as the checksum in the arrow syntax, e.g. 'sha256:e3b0c442...'
+  - priority: resources with a higher priority are downloaded first, 0 by default
+  - mirrors: alternative urls of the same file, tried in order if the url is not available
+  - rateLimit: the maximum download rate of the resource in bytes per second, see ParseByteRate, e.g. '512K'
+*/
+type ManifestEntry struct {
+	Url          string            `json:"url"`
+	Dest         string            `json:"dest"`
+	Headers      map[string]string `json:"headers"`
+	ExpectedSize *uint64           `json:"expectedSize"`
+	Checksum     string            `json:"checksum"`
+	Priority     int               `json:"priority"`
+	Mirrors      []string          `json:"mirrors"`
+	RateLimit    string            `json:"rateLimit"`
+}
+
+func IsManifestLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "{")
+}
+
+func ParseManifestLine(line string) (UserRequest, error) {
+	entry := ManifestEntry{}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(line)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&entry); err != nil {
+		return UserRequest{}, fmt.Errorf("error due to parsing manifest: %s (%v)", line, err)
+	}
+
+	if entry.Url == "" {
+		return UserRequest{}, fmt.Errorf("error due to parsing manifest: %s (url is required)", line)
+	}
+
+	url, err := ParseRequestUrl(entry.Url, line)
+	if err != nil {
+		return UserRequest{}, err
+	}
+	dest, err := ResolveRequestDest(url, entry.Dest)
+	if err != nil {
+		return UserRequest{}, err
+	}
+
+	checksum := Checksum{}
+	if entry.Checksum != "" {
+		parsed, err := ParseChecksum(entry.Checksum)
+		if err != nil {
+			return UserRequest{}, fmt.Errorf("error due to parsing checksum: %s (%v)", line, err)
+		}
+		checksum = parsed
+	}
+
+	headers := http.Header{}
+	for key, value := range entry.Headers {
+		headers.Set(key, value)
+	}
+
+	mirrors := []string{}
+	for _, mirror := range entry.Mirrors {
+		mirrorUrl, err := ParseRequestUrl(mirror, line)
+		if err != nil {
+			return UserRequest{}, err
+		}
+		mirrors = append(mirrors, mirrorUrl)
+	}
+
+	rateLimit := uint64(0)
+	if entry.RateLimit != "" {
+		parsed, err := ParseByteRate(entry.RateLimit)
+		if err != nil {
+			return UserRequest{}, fmt.Errorf("error due to parsing manifest: %s (%v)", line, err)
+		}
+		rateLimit = parsed
+	}
+
+	return UserRequest{
+		url:          url,
+		dest:         dest,
+		checksum:     checksum,
+		headers:      headers,
+		expectedSize: entry.ExpectedSize,
+		priority:     entry.Priority,
+		mirrors:      mirrors,
+		rateLimit:    rateLimit}, nil
+}
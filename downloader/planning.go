@@ -0,0 +1,190 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const PLANNING_PROBE_TIMEOUT = 10 * time.Second
+
+/*
+MeasureThroughput downloads the first probeSize bytes of the resource with a ranged request and returns the
+throughput in bytes per second, including the latency of the request. It returns 0 if the probe fails.
+*/
+func (dwn *Downloader) MeasureThroughput(ctx context.Context, rr ResourceRequest, probeSize uint64) float64 {
+	req, err := http.NewRequest("GET", rr.url, nil)
+	if err != nil {
+		return 0
+	}
+	SetRequestHeaders(req, rr.headers)
+	req.Header.Add("Range", "bytes=0-"+fmt.Sprint(min(probeSize, rr.contentLength)-1))
+
+	// the wait for the connection is not a part of the throughput
+	if err := dwn.AcquireConnection(ctx, req.URL.Hostname()); err != nil {
+		return 0
+	}
+	defer dwn.ReleaseConnection(req.URL.Hostname())
+
+	probeCtx, cancel := context.WithTimeout(ctx, PLANNING_PROBE_TIMEOUT)
+	defer cancel()
+
+	startTime := time.Now()
+	resp, err := dwn.client.Do(probeCtx, req)
+	if err != nil {
+		dwn.telemetry.Logger().Println("MeasureThroughput() failed, url:", rr.url, "error:", err) // TODO telemetry
+		return 0
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 206 {
+		dwn.telemetry.Logger().Println("MeasureThroughput() failed, url:", rr.url, "status code:", resp.StatusCode) // TODO telemetry
+		return 0
+	}
+
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil || n == 0 {
+		dwn.telemetry.Logger().Println("MeasureThroughput() failed, url:", rr.url, "error:", err) // TODO telemetry
+		return 0
+	}
+
+	return float64(n) / time.Since(startTime).Seconds()
+}
+
+/*
+MeasureThroughputs probes all downloaders at the same time, so the throughput is measured under the same contention
+as the download. The probes are spread over the resources accepting ranges. It returns nil if no resource accepts
+ranges or every probe fails.
+*/
+func (dc *DownloaderCluster) MeasureThroughputs(ctx context.Context, requests ResourceRequestList, probeSize uint64) map[*Downloader]float64 {
+	rangeRequests := ResourceRequestList{}
+	for _, rr := range requests {
+		if rr.isAcceptRange && rr.contentLength > 0 {
+			rangeRequests = append(rangeRequests, rr)
+		}
+	}
+	if len(rangeRequests) == 0 || probeSize == 0 {
+		return nil
+	}
+
+	throughputs := make(map[*Downloader]float64)
+	mutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	isAnyMeasured := false
+
+	for i, dwn := range *dc {
+		wg.Add(1)
+		go func(dwn *Downloader, rr ResourceRequest) {
+			defer wg.Done()
+			throughput := dwn.MeasureThroughput(ctx, rr, probeSize)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			throughputs[dwn] = throughput
+			isAnyMeasured = isAnyMeasured || throughput > 0
+		}(dwn, rangeRequests[i%len(rangeRequests)])
+	}
+
+	wg.Wait()
+
+	if !isAnyMeasured {
+		return nil
+	}
+	return throughputs
+}
+
+/*
+PlanChunkSizes divides the total content length among the downloaders proportionally to their throughputs.
+The chunk sizes are sorted from largest to smallest, the downloaders without throughput get no chunk.
+*/
+func PlanChunkSizes(totalContentLength uint64, throughputs map[*Downloader]float64) []uint64 {
+	sum := 0.0
+	for _, throughput := range throughputs {
+		sum += throughput
+	}
+
+	chunkSizes := []uint64{}
+	assigned := uint64(0)
+	for _, throughput := range throughputs {
+		if throughput <= 0 {
+			continue
+		}
+		chunkSize := uint64(float64(totalContentLength) * throughput / sum)
+		chunkSizes = append(chunkSizes, chunkSize)
+		assigned += chunkSize
+	}
+
+	sort.Slice(chunkSizes, func(i, j int) bool {
+		return chunkSizes[i] > chunkSizes[j]
+	})
+
+	// the rounding error goes to the largest chunk
+	if len(chunkSizes) != 0 {
+		chunkSizes[0] += totalContentLength - assigned
+	}
+
+	return chunkSizes
+}
+
+/*
+ToPlannedResources creates the resources and cuts them into segments of the planned chunk sizes, in order.
+A chunk crossing the end of a resource continues in the next resource. The resources not accepting ranges have
+a single segment which takes up its length from the chunks. If resume is true, the segments are rebuilt from the
+journal whenever a usable one exists, like ToResources.
+*/
+func (rrl *ResourceRequestList) ToPlannedResources(chunkSizes []uint64, resume bool, retryPolicy *RetryPolicy) []*Resource {
+	var resources []*Resource
+	for _, request := range *rrl {
+		resources = append(resources, request.ToResource(retryPolicy))
+	}
+
+	chunkIdx := 0
+	chunkLeft := uint64(0)
+	if len(chunkSizes) != 0 {
+		chunkLeft = chunkSizes[0]
+	}
+
+	// take up to size bytes from the current chunk and move to the next chunk if it is used up
+	take := func(size uint64) uint64 {
+		for chunkLeft == 0 && chunkIdx+1 < len(chunkSizes) {
+			chunkIdx++
+			chunkLeft = chunkSizes[chunkIdx]
+		}
+		if chunkLeft == 0 {
+			return size // no more chunks, the rest is a single segment
+		}
+		taken := min(size, chunkLeft)
+		chunkLeft -= taken
+		return taken
+	}
+
+	for _, resource := range resources {
+		if resume && resource.LoadJournal() {
+			resource.telemetry.Logger().Println("ToPlannedResources() resumed from journal, dest:", resource.dest) // TODO telemetry
+			continue
+		}
+
+		if !resource.isAcceptRange {
+			resource.SliceSegments(resource.contentLength)
+			for left := resource.contentLength; left > 0; {
+				left -= take(left)
+			}
+			continue
+		}
+
+		segments := []*ResourceSegment{}
+		for idx := uint64(0); idx < resource.contentLength; {
+			end := idx + take(resource.contentLength-idx)
+			segment := ResourceSegment{resource: resource, from: idx, to: end, ack: idx, ttl: resource.retryPolicy.Ttl(), status: PENDING}
+			segments = append(segments, &segment)
+			idx = end
+		}
+		resource._segments = segments
+	}
+
+	return resources
+}
@@ -0,0 +1,151 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentRange is the Content-Range header of a response, e.g. 'bytes 0-99/1000', 'bytes 0-99/*' or 'bytes */1000'.
+type ContentRange struct {
+	first         uint64 // inclusive, not set if unsatisfied
+	last          uint64 // inclusive, not set if unsatisfied
+	size          int64  // the complete length, -1 if unknown
+	isUnsatisfied bool   // the range is '*', sent with 416 Range Not Satisfiable
+}
+
+func ParseContentRange(value string) (ContentRange, error) {
+	cr := ContentRange{size: -1}
+
+	unit, rest, found := strings.Cut(strings.TrimSpace(value), " ")
+	if !found || unit != "bytes" {
+		return cr, fmt.Errorf("unsupported content range: %s", value)
+	}
+
+	rawRange, rawSize, found := strings.Cut(rest, "/")
+	if !found {
+		return cr, fmt.Errorf("missing complete length: %s", value)
+	}
+
+	if rawSize != "*" {
+		size, err := strconv.ParseInt(rawSize, 10, 64)
+		if err != nil || size < 0 {
+			return cr, fmt.Errorf("invalid complete length: %s", value)
+		}
+		cr.size = size
+	}
+
+	if rawRange == "*" {
+		if cr.size < 0 {
+			return cr, fmt.Errorf("unsatisfied range without complete length: %s", value)
+		}
+		cr.isUnsatisfied = true
+		return cr, nil
+	}
+
+	rawFirst, rawLast, found := strings.Cut(rawRange, "-")
+	if !found {
+		return cr, fmt.Errorf("invalid range: %s", value)
+	}
+	first, err1 := strconv.ParseUint(rawFirst, 10, 64)
+	last, err2 := strconv.ParseUint(rawLast, 10, 64)
+	if err1 != nil || err2 != nil || first > last || (cr.size >= 0 && last >= uint64(cr.size)) {
+		return cr, fmt.Errorf("invalid range: %s", value)
+	}
+	cr.first = first
+	cr.last = last
+
+	return cr, nil
+}
+
+// IsRangeResponse checks whether the response is the range from (inclusive) to (exclusive) of the resource of the length.
+func IsRangeResponse(resp *http.Response, from uint64, to uint64, contentLength uint64) bool {
+	if resp.StatusCode != 206 {
+		return false
+	}
+
+	cr, err := ParseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil || cr.isUnsatisfied {
+		return false
+	}
+	return cr.first == from && cr.last+1 == to && (cr.size < 0 || uint64(cr.size) == contentLength)
+}
+
+/*
+ProbeResourceRequest fetches the information of the resource with a GET request of the first byte, used when the
+response of HEAD is not usable. The length and the range support are read from the Content-Range of 206, or from the
+Content-Length of 200 if the server ignores the range. If the length is still unknown, the resource is downloaded
+in the streaming mode, i.e. as a single segment which grows until the end of the body.
+*/
+func (dwn *Downloader) ProbeResourceRequest(ctx context.Context, rr ResourceRequest) ResourceRequest {
+	req, err := http.NewRequest("GET", rr.url, nil)
+	if err != nil {
+		rr.status = CONNECTION_FAILED
+		rr.errorClass = ERR_OTHER
+		return rr
+	}
+	SetRequestHeaders(req, rr.headers)
+	req.Header.Set("Range", "bytes=0-0")
+
+	if err := dwn.AcquireConnection(ctx, req.URL.Hostname()); err != nil {
+		rr.errorClass = ClassifyError(err)
+		rr.status = ConnectionFailureStatus(rr.errorClass)
+		dwn.telemetry.Logger().Println("ProbeResourceRequest() interrupted, url:", rr.url, "error:", err) // TODO telemetry
+		return rr
+	}
+	defer dwn.ReleaseConnection(req.URL.Hostname())
+
+	resp, err := dwn.client.Do(ctx, req)
+	if err != nil {
+		rr.errorClass = ClassifyError(err)
+		rr.status = ConnectionFailureStatus(rr.errorClass)
+		dwn.telemetry.Logger().Println("ProbeResourceRequest() failed, url:", rr.url, "error:", err) // TODO telemetry
+		return rr
+	}
+
+	// the body is not read, it is at most one byte for 206 but can be the whole resource for 200
+	defer resp.Body.Close()
+
+	rr.etag = resp.Header.Get("ETag")
+	rr.lastModified = resp.Header.Get("Last-Modified")
+
+	switch resp.StatusCode {
+	case 206:
+		cr, err := ParseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || cr.isUnsatisfied || cr.first != 0 {
+			dwn.telemetry.Logger().Println("ProbeResourceRequest() invalid content range, url:", rr.url, "content range:", resp.Header.Get("Content-Range")) // TODO telemetry
+			rr.isStreaming = true
+		} else if cr.size < 0 {
+			rr.isStreaming = true
+		} else {
+			rr.contentLength = uint64(cr.size)
+			rr.isAcceptRange = true
+		}
+	case 200:
+		if resp.ContentLength < 0 {
+			rr.isStreaming = true
+		} else {
+			rr.contentLength = uint64(resp.ContentLength)
+		}
+	case 416:
+		// the first byte is not satisfiable, the resource is empty
+		cr, err := ParseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || !cr.isUnsatisfied || cr.size != 0 {
+			rr.status = NOT_FOUND
+			rr.errorClass = ClassifyStatusCode(resp.StatusCode)
+			return rr
+		}
+	default:
+		rr.status = NOT_FOUND
+		rr.errorClass = ClassifyStatusCode(resp.StatusCode)
+		return rr
+	}
+
+	if rr.isStreaming {
+		dwn.telemetry.Logger().Println("ProbeResourceRequest() unknown length, streaming, url:", rr.url) // TODO telemetry
+	}
+
+	return rr.CheckExpectedSize()
+}
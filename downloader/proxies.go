@@ -0,0 +1,119 @@
+package downloader
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_PROXY_SCHEME = "http"
+const DEFAULT_PROXY_PORT = "3000"
+
+type Proxy struct {
+	url            *url.URL
+	weight         int // the number of connections assigned to the proxy in each round, 1 by default
+	maxConnections int // the maximum number of connections of the proxy, 0 means unlimited
+	// the maximum number of concurrent connections from the proxy IP to an origin host, 0 means the default of the cluster
+	maxConnectionsPerHost int
+	rateLimit             *TokenBucket // shared by all downloaders of the proxy, nil means unlimited
+	health                ProxyHealth
+}
+
+/*
+ParseProxy parses a line of the proxy list in the format of '[scheme://][user:pass@]host[:port] [key=value ...]'.
+
+The scheme can be http, https or socks5 and is http by default. The port is 3000 by default.
+An IPv6 address can be written with or without brackets if the port is omitted, e.g. '::1' or '[::1]:8080'.
+
+The supported attributes are:
+  - weight: the number of connections assigned to the proxy in each round, e.g. 'weight=2'
+  - max-connections: the maximum number of connections of the proxy, e.g. 'max-connections=4'
+  - max-connections-per-host: the maximum number of concurrent connections from the proxy IP to an origin host,
+    overriding -maxConnectionsPerHost, e.g. 'max-connections-per-host=2'
+  - rate-limit: the maximum rate of all connections of the proxy in bytes per second, see ParseByteRate, e.g. 'rate-limit=1M'
+*/
+func ParseProxy(line string) (*Proxy, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty proxy")
+	}
+
+	rawUrl := fields[0]
+	if !strings.Contains(rawUrl, "://") {
+		// a bare IPv6 address, the brackets are required in the url
+		if ip := net.ParseIP(rawUrl); ip != nil && strings.Contains(rawUrl, ":") {
+			rawUrl = "[" + rawUrl + "]"
+		}
+		rawUrl = DEFAULT_PROXY_SCHEME + "://" + rawUrl
+	}
+
+	proxyUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch proxyUrl.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", proxyUrl.Scheme)
+	}
+
+	if proxyUrl.Hostname() == "" {
+		return nil, fmt.Errorf("missing proxy host")
+	}
+
+	if proxyUrl.Port() == "" {
+		proxyUrl.Host = net.JoinHostPort(proxyUrl.Hostname(), DEFAULT_PROXY_PORT)
+	}
+
+	proxy := &Proxy{url: proxyUrl, weight: 1, maxConnections: 0}
+
+	for _, attr := range fields[1:] {
+		key, rawValue, found := strings.Cut(attr, "=")
+		if !found {
+			return nil, fmt.Errorf("the attribute must be in the format of 'key=value': %s", attr)
+		}
+
+		if key == "rate-limit" {
+			rate, err := ParseByteRate(rawValue)
+			if err != nil || rate == 0 {
+				return nil, fmt.Errorf("the rate-limit must be a positive rate: %s", rawValue)
+			}
+			proxy.rateLimit = NewTokenBucket(rate)
+			continue
+		}
+
+		value, err := strconv.Atoi(rawValue)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("the value of %s must be a non-negative integer: %s", key, rawValue)
+		}
+
+		switch key {
+		case "weight":
+			if value == 0 {
+				return nil, fmt.Errorf("the weight must be greater than 0")
+			}
+			proxy.weight = value
+		case "max-connections":
+			proxy.maxConnections = value
+		case "max-connections-per-host":
+			proxy.maxConnectionsPerHost = value
+		default:
+			return nil, fmt.Errorf("unknown attribute: %s", key)
+		}
+	}
+
+	return proxy, nil
+}
+
+// Address returns the host and port of the proxy without the credentials, used to identify the proxy in the output.
+func (p *Proxy) Address() string {
+	return p.url.Host
+}
+
+// Ip returns the host of the proxy without the port, different ports of the same host share the same IP.
+func (p *Proxy) Ip() string {
+	return p.url.Hostname()
+}
@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+TokenBucket limits the rate of the bytes received. The bucket holds up to one second of tokens, so a short burst
+after an idle period is allowed. A read larger than the tokens left is allowed as well and the debt is paid off by
+waiting, the read loop does not have to read in small pieces.
+*/
+type TokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64 // in bytes per second
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(bytesPerSecond uint64) *TokenBucket {
+	return &TokenBucket{rate: float64(bytesPerSecond), tokens: float64(bytesPerSecond), last: time.Now()}
+}
+
+// Reserve takes n tokens from the bucket and returns the time to wait until they are paid off.
+func (tb *TokenBucket) Reserve(n int) time.Duration {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	now := time.Now()
+	tb.tokens = min(tb.rate, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	tb.last = now
+
+	tb.tokens -= float64(n)
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+/*
+ParseByteRate parses a rate in bytes per second with an optional K, M or G suffix (powers of 1024), e.g. '512K'.
+*/
+func ParseByteRate(raw string) (uint64, error) {
+	multiplier := uint64(1)
+	trimmed := strings.ToUpper(strings.TrimSpace(raw))
+	switch {
+	case strings.HasSuffix(trimmed, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(trimmed, "M"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(trimmed, "G"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier != 1 {
+		trimmed = trimmed[:len(trimmed)-1]
+	}
+
+	value, err := strconv.ParseUint(trimmed, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate: %s", raw)
+	}
+	return value * multiplier, nil
+}
+
+// LimitRate limits the total rate of all downloaders in the cluster, 0 means unlimited.
+func (dc *DownloaderCluster) LimitRate(bytesPerSecond uint64) {
+	var bucket *TokenBucket = nil
+	if bytesPerSecond != 0 {
+		bucket = NewTokenBucket(bytesPerSecond)
+	}
+	for _, dwn := range *dc {
+		dwn.rateLimit = bucket
+	}
+}
+
+/*
+WaitRate blocks until the n bytes received for the resource are paid off in the global, proxy and resource limits,
+or the context is done.
+*/
+func (dwn *Downloader) WaitRate(ctx context.Context, r *Resource, n int) {
+	wait := time.Duration(0)
+	if dwn.rateLimit != nil {
+		wait = max(wait, dwn.rateLimit.Reserve(n))
+	}
+	if dwn.proxy != nil && dwn.proxy.rateLimit != nil {
+		wait = max(wait, dwn.proxy.rateLimit.Reserve(n))
+	}
+	if r.rateLimit != nil {
+		wait = max(wait, r.rateLimit.Reserve(n))
+	}
+	if wait > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,848 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ResourceStatus int
+
+const (
+	PENDING ResourceStatus = iota
+	DOWNLOADING
+	DOWNLOADED
+	DOWNLOAD_FAILED
+)
+
+type Resource struct {
+	url              string
+	dest             string
+	contentLength    uint64 // in bytes
+	isAcceptRange    bool
+	isStreaming      bool   // the length is unknown, the single segment grows with the body until the end of it
+	etag             string // empty if not provided by the server
+	lastModified     string // empty if not provided by the server
+	checksum         Checksum
+	headers          http.Header  // extra request headers, nil if not provided
+	priority         int          // resources with a higher priority are downloaded first
+	rateLimit        *TokenBucket // nil means unlimited
+	retryPolicy      *RetryPolicy // nil means DefaultRetryPolicy
+	telemetry        *Telemetry   // of the downloader which fetched the request, nil means nothing is logged
+	_fd              *os.File
+	_segments        []*ResourceSegment
+	_writtenSegments []*ResourceSegment
+	_mutex           sync.Mutex // guards _segments, _writtenSegments, the progress and the speculative downloads of the segments
+	_journalMutex    sync.Mutex // guards _fd, the journal file and _journal
+	_journal         []byte     // the journal last written, see CheckpointJournal
+	_isChanged       bool       // the resource has changed on the server during the download
+	_actualChecksum  Checksum   // the checksum of the downloaded file, empty if not verified
+	_isCorrupted     bool       // the downloaded file does not match the expected checksum
+	_host            string     // the origin host of the url, see Host
+	_hostOnce        sync.Once
+
+	// the cancellation of the resource, guarded by _mutex
+	_ctx         context.Context    // the context of the downloads of the resource, see Context
+	_cancel      context.CancelFunc // cancels _ctx, see Cancel
+	_isCancelled bool               // the resource is cancelled, its downloads are not resumed until the next run
+}
+
+// Host returns the origin host of the url, it is parsed once for the ConnectionLimiter.
+func (r *Resource) Host() string {
+	r._hostOnce.Do(func() {
+		if urlObj, err := url.Parse(r.url); err == nil {
+			r._host = urlObj.Hostname()
+		}
+	})
+	return r._host
+}
+
+func (r *Resource) SliceSegments(chunkSize uint64) {
+	if r.isAcceptRange {
+		segments := []*ResourceSegment{}
+		for idx := uint64(0); idx < r.contentLength; {
+			maxChunkSize := min(r.contentLength, idx+chunkSize)
+			segment := ResourceSegment{resource: r, from: idx, to: maxChunkSize, ack: idx, ttl: r.retryPolicy.Ttl(), status: PENDING}
+			segments = append(segments, &segment)
+			idx = maxChunkSize
+		}
+		r._segments = segments
+	} else {
+		segment := ResourceSegment{resource: r, from: 0, to: r.contentLength, ack: 0, ttl: r.retryPolicy.Ttl(), status: PENDING}
+		r._segments = []*ResourceSegment{&segment}
+	}
+}
+
+// EndStreaming sets the length of the streaming resource at the end of the body, the file is truncated to the length.
+func (r *Resource) EndStreaming(length uint64) error {
+	r._journalMutex.Lock()
+	defer r._journalMutex.Unlock()
+
+	r.contentLength = length
+	if r._fd == nil {
+		return fmt.Errorf("the file is not opened")
+	}
+	return r._fd.Truncate(int64(length))
+}
+
+/*
+Cancel cancels the downloads of the resource, the ones in progress and the ones to come, the other resources are not
+affected. The unfinished segments are left pending in the journal, so the resource can be resumed later.
+*/
+func (r *Resource) Cancel() {
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	r._isCancelled = true
+	if r._cancel != nil {
+		r._cancel()
+	}
+}
+
+func (r *Resource) IsCancelled() bool {
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	return r._isCancelled
+}
+
+// StartContext derives the context of the downloads of the resource from the parent, it is done if cancelled already.
+func (r *Resource) StartContext(parent context.Context) {
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	r._ctx, r._cancel = context.WithCancel(parent)
+	if r._isCancelled {
+		r._cancel()
+	}
+}
+
+// Context returns the context of the downloads of the resource, context.Background() if not started.
+func (r *Resource) Context() context.Context {
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	if r._ctx == nil {
+		return context.Background()
+	}
+	return r._ctx
+}
+
+// IsAcceptRange reads under the lock, the resource can be downgraded by another download.
+func (r *Resource) IsAcceptRange() bool {
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	return r.isAcceptRange
+}
+
+/*
+Downgrade turns the resource into a single segment without range, because the server does not honour the ranges.
+The segment becomes the whole resource from the beginning, the other segments are superseded and their downloads
+are cancelled. It returns false if the resource does not accept ranges anymore, e.g. it has already been downgraded.
+*/
+func (r *Resource) Downgrade(seg *ResourceSegment) bool {
+	r._mutex.Lock()
+
+	if !r.isAcceptRange || seg._isSuperseded {
+		r._mutex.Unlock()
+		return false
+	}
+
+	cancels := []context.CancelFunc{}
+	for _, other := range append(r._segments, r._writtenSegments...) {
+		if other == seg {
+			continue
+		}
+		other._isSuperseded = true
+		if other.status == DOWNLOADING && other._downloadCancel != nil {
+			cancels = append(cancels, other._downloadCancel)
+		}
+	}
+
+	r.isAcceptRange = false
+	r._segments = []*ResourceSegment{seg}
+	r._writtenSegments = []*ResourceSegment{}
+	seg.from = 0
+	seg.to = r.contentLength
+	seg.ack = 0
+	seg._startAck = 0
+
+	r._mutex.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+	return true
+}
+
+func (r *Resource) OpenFile() error {
+	r._journalMutex.Lock()
+	defer r._journalMutex.Unlock()
+
+	if r._fd != nil {
+		return nil
+	}
+
+	f, err := os.OpenFile(r.dest, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	r._fd = f
+	return nil
+}
+
+func (r *Resource) CloseFile() error {
+	r._journalMutex.Lock()
+	defer r._journalMutex.Unlock()
+
+	if r._fd == nil {
+		return nil
+	}
+
+	err := r._fd.Close()
+	if err != nil {
+		return err
+	}
+
+	r._fd = nil
+	return nil
+}
+
+/*
+Validator returns the value used in the If-Range header of ranged requests, or an empty string if there is none.
+Weak ETags are not allowed in If-Range, the Last-Modified date is used instead.
+*/
+func (r *Resource) Validator() string {
+	if r.etag != "" && !strings.HasPrefix(r.etag, "W/") {
+		return r.etag
+	}
+	return r.lastModified
+}
+
+// IsChangedResponse checks whether the response is for a different version of the resource than the one in the preflight.
+func (r *Resource) IsChangedResponse(resp *http.Response) bool {
+	if etag := resp.Header.Get("ETag"); r.etag != "" && etag != "" {
+		return strings.TrimPrefix(etag, "W/") != strings.TrimPrefix(r.etag, "W/")
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); r.lastModified != "" && lastModified != "" {
+		return lastModified != r.lastModified
+	}
+	return false
+}
+
+/*
+MarkChanged marks the resource as changed on the server. The remaining segments are not downloaded and the journal is
+removed, because the data written so far belongs to another version of the resource and must not be resumed.
+*/
+func (r *Resource) MarkChanged() {
+	r._mutex.Lock()
+	r._isChanged = true
+	r._mutex.Unlock()
+
+	if err := r.RemoveJournal(); err != nil {
+		r.telemetry.Logger().Println("MarkChanged() failed to remove journal, dest:", r.dest, "error:", err) // TODO telemetry
+	}
+}
+
+func (r *Resource) IsChanged() bool {
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	return r._isChanged
+}
+
+/*
+VerifyChecksum computes the checksum of the downloaded file and compares it with the expected one.
+The resource is marked as corrupted if the checksums do not match or the file can not be read.
+*/
+func (r *Resource) VerifyChecksum() {
+	if r.checksum.IsEmpty() {
+		return
+	}
+
+	actual, err := r.checksum.ComputeFile(r.dest)
+
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	if err != nil {
+		r.telemetry.Logger().Println("VerifyChecksum() failed to compute checksum, dest:", r.dest, "error:", err) // TODO telemetry
+		r._isCorrupted = true
+		return
+	}
+
+	r._actualChecksum = actual
+	r._isCorrupted = actual != r.checksum
+	if r._isCorrupted {
+		r.telemetry.Logger().Println("VerifyChecksum() checksum mismatch, dest:", r.dest, "expected:", r.checksum, "actual:", actual) // TODO telemetry
+	}
+}
+
+func (r *Resource) IsCorrupted() bool {
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	return r._isCorrupted
+}
+
+// Segments returns the unfinished and the downloaded segments of the resource, ordered by their start.
+func (r *Resource) Segments() []*ResourceSegment {
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	segs := append([]*ResourceSegment{}, r._segments...)
+	segs = append(segs, r._writtenSegments...)
+	sort.Slice(segs, func(i, j int) bool {
+		return segs[i].from < segs[j].from
+	})
+	return segs
+}
+
+// RemainingLength returns the number of bytes not yet received in the unfinished segments.
+func (r *Resource) RemainingLength() uint64 {
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	remaining := uint64(0)
+	for _, seg := range r._segments {
+		remaining += seg.to - seg.ack
+	}
+	return remaining
+}
+
+func (r *Resource) IsCompleted() bool {
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	return len(r._segments) == 0
+}
+
+/*
+PENDING: All segments are pending
+DOWNLOADING: At least one segment is downloading
+DOWNLOADED: All segments are downloaded successfully
+DOWNLOAD_FAILED: No segments are downloading/pending and at least one segment is downloaded unsuccessfully,
+or the resource has changed on the server, or the downloaded file does not match the expected checksum
+*/
+func (r *Resource) Status() ResourceStatus {
+	if r.IsChanged() || r.IsCorrupted() {
+		return DOWNLOAD_FAILED
+	}
+
+	if r.IsCompleted() {
+		return DOWNLOADED
+	}
+
+	// if all segments are pending
+	isAllPending := true
+	isAllDownloaded := true
+	for _, seg := range r._segments {
+		if seg.status != PENDING {
+			isAllPending = false
+		}
+		if seg.status == DOWNLOADING {
+			return DOWNLOADING
+		}
+		if seg.status != DOWNLOADED {
+			isAllDownloaded = false
+		}
+	}
+
+	if isAllPending && !isAllDownloaded {
+		return PENDING
+	}
+
+	if isAllDownloaded && !isAllPending {
+		return DOWNLOADED
+	}
+
+	return DOWNLOAD_FAILED
+}
+
+// WriteAt fails once the file is closed, e.g. a speculative duplicate still writing after the resource is completed.
+func (r *Resource) WriteAt(b []byte, off int64) (n int, err error) {
+	r._journalMutex.Lock()
+	defer r._journalMutex.Unlock()
+
+	if r._fd == nil {
+		return 0, fmt.Errorf("the file is not opened")
+	}
+	return r._fd.WriteAt(b, off)
+}
+
+type ResourceSegment struct {
+	resource *Resource
+	from     uint64 // inclusive
+	to       uint64 // exclusive
+	ack      uint64 // from <= ack <= to
+	ttl      uint8
+	status   ResourceStatus
+	// the downloaders which failed to download the segment, used to retry the segment through another proxy
+	_failedDownloaders   []*Downloader
+	_downloader          *Downloader        // the downloader of the current or last download
+	_downloadCancel      context.CancelFunc // cancels the request of the current download
+	_startTime           time.Time          // the start time of the current download
+	_startAck            uint64             // the ack at the start of the current download
+	_original            *ResourceSegment   // the segment raced by this speculative duplicate, nil if not speculative
+	_speculative         *ResourceSegment   // the speculative duplicate racing this segment, nil if there is none
+	_isDoneBySpeculative bool               // the speculative duplicate has downloaded the rest of the segment
+	_retryAfter          time.Duration      // the Retry-After of the last throttled download, 0 if not provided
+	_failures            map[ErrorClass]int // the number of failed downloads of each class
+	_lastError           ErrorClass         // the class of the last failed download, valid if _failures is not nil
+	_isSuperseded        bool               // replaced by the single segment of the downgraded resource, guarded by the resource
+}
+
+func (rs *ResourceSegment) ContentLength() uint64 {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	return rs.to - rs.from
+}
+
+// Progress returns the range and the ack of the segment, they are read together under the lock.
+func (rs *ResourceSegment) Progress() (from uint64, to uint64, ack uint64) {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	return rs.from, rs.to, rs.ack
+}
+
+// RemainingLength returns the number of bytes not yet received, 0 if the segment has been split below its ack.
+func (rs *ResourceSegment) RemainingLength() uint64 {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	if rs.ack >= rs.to {
+		return 0
+	}
+	return rs.to - rs.ack
+}
+
+// IsAcknowledged returns true if every byte of the segment has been written, the end can be moved by Split.
+func (rs *ResourceSegment) IsAcknowledged() bool {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	return rs.ack >= rs.to
+}
+
+// Downloader returns the downloader of the current or last download, nil if the segment has not been downloaded.
+func (rs *ResourceSegment) Downloader() *Downloader {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	return rs._downloader
+}
+
+func (rs *ResourceSegment) ReportFailedBy(dwn *Downloader) {
+	rs._failedDownloaders = append(rs._failedDownloaders, dwn)
+}
+
+/*
+FailureScore tells how bad the downloader is for retrying the segment:
+0 if the segment has not failed through the proxy IP of the downloader,
+1 if the segment has failed through another downloader with the same proxy IP,
+2 if the segment has failed through the downloader itself.
+*/
+func (rs *ResourceSegment) FailureScore(dwn *Downloader) int {
+	score := 0
+	for _, failed := range rs._failedDownloaders {
+		if failed == dwn {
+			return 2
+		}
+		if failed.proxy != nil && dwn.proxy != nil && failed.proxy.Ip() == dwn.proxy.Ip() {
+			score = 1
+		}
+	}
+	return score
+}
+
+func (rs *ResourceSegment) IsSettled() bool {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	return rs.status == DOWNLOADED || rs.status == DOWNLOAD_FAILED
+}
+
+func (rs *ResourceSegment) IsDownloading() bool {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	return rs.status == DOWNLOADING
+}
+
+// StartDownload marks the segment downloading by the downloader and opens the destination file, the error of the file is returned.
+func (rs *ResourceSegment) StartDownload(dwn *Downloader) error {
+	if rs.status != PENDING {
+		panic("The segment is not pending")
+	}
+	if rs.ttl == 0 {
+		panic("The segment has no more ttl")
+	}
+	rs.resource._mutex.Lock()
+	rs._downloader = dwn
+	rs.status = DOWNLOADING
+	rs._startTime = time.Now()
+	rs._startAck = rs.ack
+	rs.resource._mutex.Unlock()
+
+	return rs.resource.OpenFile()
+}
+
+func (rs *ResourceSegment) CancelDownload() {
+	if rs.status != DOWNLOADING {
+		panic("The segment is not downloading")
+	}
+	if rs.ttl == 0 {
+		panic("The segment has no more ttl")
+	}
+	rs.resource._mutex.Lock()
+	rs.ttl--
+	if rs.ttl == 0 {
+		rs.status = DOWNLOAD_FAILED
+	} else {
+		rs.status = PENDING
+	}
+	rs.resource._mutex.Unlock()
+
+	rs.detachSpeculation()
+
+	if err := rs.resource.SaveJournal(); err != nil {
+		rs.resource.telemetry.Logger().Println("CancelDownload() failed to save journal, dest:", rs.resource.dest, "error:", err) // TODO telemetry
+	}
+}
+
+// RequeueDownload returns the segment to pending without losing ttl, e.g. the server asked to retry later.
+func (rs *ResourceSegment) RequeueDownload() {
+	if rs.status != DOWNLOADING {
+		panic("The segment is not downloading")
+	}
+	rs.resource._mutex.Lock()
+	rs.status = PENDING
+	rs.resource._mutex.Unlock()
+
+	rs.detachSpeculation()
+
+	if err := rs.resource.SaveJournal(); err != nil {
+		rs.resource.telemetry.Logger().Println("RequeueDownload() failed to save journal, dest:", rs.resource.dest, "error:", err) // TODO telemetry
+	}
+}
+
+/*
+ThrottleDownload returns the throttled segment to pending without losing ttl, the server asked to retry later.
+The segment fails once it is throttled more times than the retries of ERR_THROTTLED in the retry policy of the
+resource. It returns false if the segment has failed.
+*/
+func (rs *ResourceSegment) ThrottleDownload() bool {
+	if rs._failures == nil {
+		rs._failures = make(map[ErrorClass]int)
+	}
+	rs._failures[ERR_THROTTLED]++
+	rs._lastError = ERR_THROTTLED
+
+	if !rs.resource.retryPolicy.ShouldRetry(ERR_THROTTLED, rs._failures[ERR_THROTTLED]) {
+		rs.AbortDownload()
+		return false
+	}
+	rs.RequeueDownload()
+	return true
+}
+
+/*
+FailDownload cancels the download after a failure of the class. The segment is returned to pending unless it runs
+out of ttl or the retry policy of the resource gives up on the class.
+*/
+func (rs *ResourceSegment) FailDownload(class ErrorClass) {
+	if rs._failures == nil {
+		rs._failures = make(map[ErrorClass]int)
+	}
+	rs._failures[class]++
+	rs._lastError = class
+
+	if !rs.resource.retryPolicy.ShouldRetry(class, rs._failures[class]) {
+		rs.resource._mutex.Lock()
+		rs.ttl = 1 // the last attempt is taken by CancelDownload
+		rs.resource._mutex.Unlock()
+	}
+	rs.CancelDownload()
+}
+
+// AbortDownload fails the segment regardless of the remaining ttl, it will not be downloaded again.
+func (rs *ResourceSegment) AbortDownload() {
+	if rs.status != DOWNLOADING {
+		panic("The segment is not downloading")
+	}
+	rs.resource._mutex.Lock()
+	rs.status = DOWNLOAD_FAILED
+	rs.resource._mutex.Unlock()
+
+	rs.detachSpeculation()
+
+	if err := rs.resource.SaveJournal(); err != nil {
+		rs.resource.telemetry.Logger().Println("AbortDownload() failed to save journal, dest:", rs.resource.dest, "error:", err) // TODO telemetry
+	}
+}
+
+func (rs *ResourceSegment) FinishDownload() {
+	if rs.status != DOWNLOADING {
+		panic("The segment is not downloading")
+	}
+	rs.resource._mutex.Lock()
+	rs.status = DOWNLOADED
+	rs.resource._mutex.Unlock()
+
+	// the speculative duplicate is not in the resource, it only completes the original segment
+	if rs.IsSpeculative() {
+		rs.completeOriginal()
+		return
+	}
+
+	rs.detachSpeculation()
+
+	rs.resource._mutex.Lock()
+
+	// remove from _segments in resource
+	for i, seg := range rs.resource._segments {
+		if seg == rs {
+			rs.resource._segments = append(rs.resource._segments[:i], rs.resource._segments[i+1:]...)
+			break
+		}
+	}
+
+	// append to _writtenSegments in resource
+	rs.resource._writtenSegments = append(rs.resource._writtenSegments, rs)
+
+	isCompleted := len(rs.resource._segments) == 0
+
+	rs.resource._mutex.Unlock()
+
+	// if all segments are downloaded, close the file and verify it, the journal is no longer needed
+	if isCompleted {
+		rs.resource.CloseFile()
+		rs.resource.VerifyChecksum()
+		if err := rs.resource.RemoveJournal(); err != nil {
+			rs.resource.telemetry.Logger().Println("FinishDownload() failed to remove journal, dest:", rs.resource.dest, "error:", err) // TODO telemetry
+		}
+	} else if err := rs.resource.SaveJournal(); err != nil {
+		rs.resource.telemetry.Logger().Println("FinishDownload() failed to save journal, dest:", rs.resource.dest, "error:", err) // TODO telemetry
+	}
+}
+
+func (rs *ResourceSegment) WriteAt(b []byte, off int64) (n int, err error) {
+	if rs.status != DOWNLOADING {
+		return 0, fmt.Errorf("the segment is not downloading")
+	}
+	return rs.resource.WriteAt(b, off)
+}
+
+/*
+Rewind moves the start of the segment to its ack before a ranged request and returns the range to request. Without
+range, the segment is downloaded again from the beginning of the resource, a streaming segment grows from nothing.
+*/
+func (rs *ResourceSegment) Rewind() (from uint64, to uint64) {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	if rs.resource.isAcceptRange {
+		rs.from = rs.ack
+	} else {
+		rs.ack = 0
+		rs._startAck = 0 // the throughput counts from the beginning again
+		if rs.resource.isStreaming {
+			rs.to = 0
+		}
+	}
+	return rs.from, rs.to
+}
+
+// Acknowledge advances the ack over the bytes written to the file, a streaming segment and resource grow with it.
+func (rs *ResourceSegment) Acknowledge(n uint64) {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	rs.ack += n
+	if rs.resource.isStreaming {
+		rs.to = rs.ack
+		rs.resource.contentLength = max(rs.resource.contentLength, rs.ack)
+	} else {
+		rs.ack = min(rs.ack, rs.to) // never past the end moved by Split, the journal would be rejected
+	}
+}
+
+// AcknowledgeAll marks every byte of the segment as written, e.g. by the speculative duplicate which won the race.
+func (rs *ResourceSegment) AcknowledgeAll() {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	rs.ack = rs.to
+}
+
+// Split gives the ratio of the remaining bytes of the segment to a new pending segment, 0.5 splits it in half.
+func (firstHalf *ResourceSegment) Split(ratio float64) *ResourceSegment {
+	r := firstHalf.resource
+
+	r._mutex.Lock()
+	remaining := firstHalf.to - firstHalf.ack
+	middle := firstHalf.to - uint64(float64(remaining)*ratio)
+	end := firstHalf.to
+	secondHalf := ResourceSegment{resource: r, from: middle, to: end, ack: middle, ttl: r.retryPolicy.Ttl(), status: PENDING}
+	firstHalf.to = middle
+	r._segments = append(r._segments, &secondHalf)
+	r._mutex.Unlock()
+
+	if err := r.SaveJournal(); err != nil {
+		r.telemetry.Logger().Println("Split() failed to save journal, dest:", r.dest, "error:", err) // TODO telemetry
+	}
+
+	return &secondHalf
+}
+
+func (rs *ResourceSegment) IsSuperseded() bool {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	return rs._isSuperseded
+}
+
+func (rs *ResourceSegment) SetDownloadCancel(cancel context.CancelFunc) {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	rs._downloadCancel = cancel
+}
+
+// Received returns the number of bytes acknowledged since the current download started.
+func (rs *ResourceSegment) Received() uint64 {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	return rs.received()
+}
+
+// received is Received with the lock held, the ack rewound without range never counts as a negative progress.
+func (rs *ResourceSegment) received() uint64 {
+	if rs.ack < rs._startAck {
+		return 0
+	}
+	return rs.ack - rs._startAck
+}
+
+/*
+EstimatedRemainingTime estimates the time to finish the current download from the throughput since it started.
+It returns math.MaxInt64 if nothing has been received yet.
+*/
+func (rs *ResourceSegment) EstimatedRemainingTime() time.Duration {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	received := rs.received()
+	if rs.status != DOWNLOADING || received == 0 {
+		return math.MaxInt64
+	}
+
+	elapsed := time.Since(rs._startTime)
+	return time.Duration(float64(elapsed) * float64(rs.to-rs.ack) / float64(received))
+}
+
+// Throughput returns the throughput of the current download in bytes per second, 0 if nothing is received yet.
+func (rs *ResourceSegment) Throughput() float64 {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	received := rs.received()
+	if rs.status != DOWNLOADING || received == 0 {
+		return 0
+	}
+	return float64(received) / time.Since(rs._startTime).Seconds()
+}
+
+/*
+Speculate creates a speculative duplicate of the downloading segment which races it from the current ack.
+The first one to finish completes the segment and cancels the other. It returns nil if the segment is not
+downloading or is already raced by another duplicate.
+*/
+func (rs *ResourceSegment) Speculate() *ResourceSegment {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	if rs.status != DOWNLOADING || rs._speculative != nil || rs._original != nil || rs.ack >= rs.to {
+		return nil
+	}
+
+	dup := &ResourceSegment{resource: rs.resource, from: rs.ack, to: rs.to, ack: rs.ack, ttl: 1, status: PENDING, _original: rs}
+	rs._speculative = dup
+	return dup
+}
+
+func (rs *ResourceSegment) IsSpeculative() bool {
+	return rs._original != nil
+}
+
+// IsSpeculationAlive returns false if the speculative duplicate has lost the race or the original segment has failed.
+func (rs *ResourceSegment) IsSpeculationAlive() bool {
+	if rs._original == nil {
+		return true
+	}
+
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	return rs._original._speculative == rs
+}
+
+func (rs *ResourceSegment) IsDoneBySpeculative() bool {
+	rs.resource._mutex.Lock()
+	defer rs.resource._mutex.Unlock()
+
+	return rs._isDoneBySpeculative
+}
+
+// completeOriginal is called when the speculative duplicate wins the race, the original segment is cancelled.
+func (rs *ResourceSegment) completeOriginal() {
+	rs.resource._mutex.Lock()
+
+	var cancel context.CancelFunc
+	original := rs._original
+	if original._speculative == rs {
+		original._speculative = nil
+		if original.status == DOWNLOADING {
+			original._isDoneBySpeculative = true
+			cancel = original._downloadCancel
+		}
+	}
+
+	rs.resource._mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// detachSpeculation unlinks the segment from its original or speculative duplicate, the duplicate is cancelled.
+func (rs *ResourceSegment) detachSpeculation() {
+	rs.resource._mutex.Lock()
+
+	var cancel context.CancelFunc
+	if rs._original != nil && rs._original._speculative == rs {
+		rs._original._speculative = nil
+	}
+	if dup := rs._speculative; dup != nil {
+		rs._speculative = nil
+		cancel = dup._downloadCancel
+	}
+
+	rs.resource._mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
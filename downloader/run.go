@@ -0,0 +1,304 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+/*
+Options are the settings of Run, the same as the flags of the command line tool. The zero value is not usable, start
+from DefaultOptions.
+*/
+type Options struct {
+	Connections              int                                      // the number of connections in total, or the maximum with IsAutoConnectionsEnabled
+	IsAutoConnectionsEnabled bool                                     // tune the number of connections of every proxy, see ConnectionTuner
+	MaxConnectionsPerHost    int                                      // from one proxy IP to one origin host, 0 means unlimited
+	RateLimit                uint64                                   // of all connections in bytes per second, 0 means unlimited
+	RetryPolicy              *RetryPolicy                             // see ParseRetryPolicy
+	Timeouts                 *Timeouts                                // see ParseTimeouts
+	Scheduler                string                                   // one of SCHEDULER_NAMES
+	IsPlanEnabled            bool                                     // size the segments by the throughput measured before the download
+	PlanProbeSize            uint64                                   // the number of bytes downloaded by each connection to measure the throughput
+	IsEndgameEnabled         bool                                     // race the slowest segments with the idle connections at the end
+	IsResumeEnabled          bool                                     // resume the download from the journal files next to the destinations
+	OnUnavailable            string                                   // ON_UNAVAILABLE_PROMPT, ON_UNAVAILABLE_SKIP or ON_UNAVAILABLE_ABORT
+	Confirm                  func(unavailable []SummaryResource) bool // asked with ON_UNAVAILABLE_PROMPT, abort if nil
+	Output                   io.Writer                                // the messages, the progress bars and the report, discarded if nil
+	IsProgressShown          bool                                     // draw the progress bars on Output during the download
+	LogPath                  string                                   // the log is discarded if empty
+	Name                     string                                   // the name of the execution in the time log
+	TimeLogPath              string                                   // the time log is discarded if empty
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		RetryPolicy:      DefaultRetryPolicy(),
+		Timeouts:         DefaultTimeouts(),
+		Scheduler:        "lpt",
+		PlanProbeSize:    1024 * 1024,
+		IsEndgameEnabled: true,
+		OnUnavailable:    ON_UNAVAILABLE_SKIP,
+		Name:             "default"}
+}
+
+// Validate checks the options before anything is downloaded.
+func (o *Options) Validate() error {
+	if o.Connections == 0 && !o.IsAutoConnectionsEnabled {
+		return fmt.Errorf("the number of connections must be provided without auto connections")
+	}
+	if o.Connections < 0 {
+		return fmt.Errorf("the number of connections must be greater than 0")
+	}
+	if o.MaxConnectionsPerHost < 0 {
+		return fmt.Errorf("the maximum number of connections per host must not be negative")
+	}
+	if o.OnUnavailable != ON_UNAVAILABLE_PROMPT && o.OnUnavailable != ON_UNAVAILABLE_SKIP && o.OnUnavailable != ON_UNAVAILABLE_ABORT {
+		return fmt.Errorf("the action on unavailable resources must be one of prompt, skip and abort")
+	}
+	if o.RetryPolicy == nil || o.Timeouts == nil {
+		return fmt.Errorf("the retry policy and the timeouts are required")
+	}
+	if _, err := NewScheduler(o.Scheduler, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+func IsAllResourceRequestAvailable(requests ResourceRequestList) bool {
+	for _, request := range requests {
+		if request.status != AVAILABLE {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+Run downloads the requests through the proxies and returns the summary of every requested resource. The requests
+are the lines of a request list and the proxies are the lines of a proxy list, in the formats of the command line
+tool, see ToUserRequests and ParseProxy. An error is returned only if the options or the lists are invalid.
+
+Once the context is done, the download stops and the progress is saved to the journals, the summary is then
+EXIT_INTERRUPTED. Every Run has its own telemetry and log, so several Runs can be in progress at the same time with
+different destinations. The log files are closed on return.
+*/
+func (o *Options) Run(ctx context.Context, requests []string, proxies []string) (Summary, error) {
+	if err := o.Validate(); err != nil {
+		return Summary{}, err
+	}
+
+	telemetry := NewTelemetry()
+
+	out := o.Output
+	if out == nil {
+		out = io.Discard
+	}
+
+	proxyIps := IpList(proxies)
+	numOfConn := o.Connections
+	if numOfConn == 0 {
+		numOfConn = AUTO_CONNECTIONS_PER_PROXY * len(proxyIps)
+	}
+	downloaders, err := proxyIps.ToDownloaderCluster(numOfConn, o.Timeouts, telemetry)
+	if err != nil {
+		return Summary{}, err
+	}
+	downloaders.LimitRate(o.RateLimit)
+	downloaders.LimitConnections(o.MaxConnectionsPerHost)
+
+	originalUserRequests := OriginalUserRequestList(requests)
+	userRequests, err := originalUserRequests.ToUserRequests()
+	if err != nil {
+		return Summary{}, err
+	}
+
+	allResourceRequests := downloaders.FetchResourceRequests(ctx, userRequests)
+
+	if ctx.Err() != nil {
+		fmt.Fprintln(out, "Preflight interrupted")
+		return NewSummary(allResourceRequests, nil, true), nil
+	}
+
+	/////////////////////////
+	/// Check if all resources are available
+	/////////////////////////
+
+	resourceRequests := ResourceRequestList{}
+
+	if len(downloaders) == 0 {
+		fmt.Fprintln(out, "No downloader available")
+		return NewSummary(allResourceRequests, nil, false), nil
+	}
+
+	for _, rr := range allResourceRequests {
+		if rr.fault == PROXY_FAULT {
+			fmt.Fprintf(out, "Proxy fault: %s, not reachable through proxy %s, fetched by proxy %s\n", rr.url, telemetry.GetDownloaderIps(rr.failedBy), telemetry.GetDownloaderIp(rr.fetchedBy))
+		}
+	}
+
+	if !IsAllResourceRequestAvailable(allResourceRequests) {
+		fmt.Fprintln(out, "The following resources are not available.")
+
+		for _, rr := range allResourceRequests {
+			if rr.status == AVAILABLE {
+				resourceRequests = append(resourceRequests, rr)
+			} else {
+				switch rr.status {
+				case NOT_FOUND:
+					fmt.Fprintf(out, "Status code != 200: %s, fetched by proxy %s\n", rr.url, telemetry.GetDownloaderIp(rr.fetchedBy))
+				case CONNECTION_TIMEOUT:
+					fmt.Fprintf(out, "Connection timeout: %s, fetched by proxy %s\n", rr.url, telemetry.GetDownloaderIp(rr.fetchedBy))
+				case CONNECTION_REFUSED:
+					fmt.Fprintf(out, "Connection refused: %s, fetched by proxy %s\n", rr.url, telemetry.GetDownloaderIp(rr.fetchedBy))
+				case CONNECTION_FAILED:
+					fmt.Fprintf(out, "Connection failed (%s): %s, fetched by proxy %s\n", rr.errorClass, rr.url, telemetry.GetDownloaderIp(rr.fetchedBy))
+				case SIZE_MISMATCH:
+					fmt.Fprintf(out, "Size mismatch (expected %d, got %d): %s, fetched by proxy %s\n", *rr.expectedSize, rr.contentLength, rr.url, telemetry.GetDownloaderIp(rr.fetchedBy))
+				}
+
+				switch rr.fault {
+				case ORIGIN_FAULT:
+					fmt.Fprintf(out, "  Origin fault: not reachable through proxy %s\n", telemetry.GetDownloaderIps(rr.failedBy))
+				case UNKNOWN_FAULT:
+					fmt.Fprintf(out, "  Unknown fault: no other proxy to retry\n")
+				}
+			}
+		}
+
+		switch o.OnUnavailable {
+		case ON_UNAVAILABLE_SKIP:
+			fmt.Fprintln(out, "Skip the unavailable resources")
+		case ON_UNAVAILABLE_ABORT:
+			fmt.Fprintln(out, "Abort the download")
+			return NewSummary(allResourceRequests, nil, false), nil
+		default:
+			unavailable := []SummaryResource{}
+			for _, sr := range NewSummary(allResourceRequests, nil, false).Resources {
+				if sr.Status == "unavailable" {
+					unavailable = append(unavailable, sr)
+				}
+			}
+			// nothing is downloaded without someone to ask
+			isConfirmed := o.Confirm != nil && o.Confirm(unavailable)
+			if ctx.Err() != nil {
+				return NewSummary(allResourceRequests, nil, true), nil
+			}
+			if !isConfirmed {
+				return NewSummary(allResourceRequests, nil, false), nil
+			}
+		}
+	} else {
+		resourceRequests = allResourceRequests
+	}
+
+	if len(resourceRequests) == 0 {
+		fmt.Fprintln(out, "No resource to download")
+		return NewSummary(allResourceRequests, nil, false), nil
+	}
+
+	/////////////////////////
+	/// Init telemetry and start download process
+	/////////////////////////
+
+	var progress io.Writer = nil
+	if o.IsProgressShown {
+		progress = o.Output
+	}
+
+	if err := telemetry.Init(o.LogPath, o.Name, o.TimeLogPath, progress); err != nil {
+		return Summary{}, err
+	}
+	defer telemetry.Close()
+
+	/////////////////////////
+	/// Calculate the chunk size for each downloader
+	/////////////////////////
+
+	chunkSize := uint64(math.Ceil(float64(resourceRequests.TotalContentLength()) / float64(len(downloaders))))
+
+	var throughputs map[*Downloader]float64 = nil
+	if o.IsPlanEnabled {
+		throughputs = downloaders.MeasureThroughputs(ctx, resourceRequests, o.PlanProbeSize)
+		if throughputs == nil {
+			telemetry.Logger().Println("Run() unable to measure the throughput, fall back to equal chunk sizes") // TODO telemetry
+		}
+	}
+
+	/////////////////////////
+	/// Create resources and split them into segments
+	/////////////////////////
+
+	var resources []*Resource
+	if throughputs != nil {
+		resources = resourceRequests.ToPlannedResources(PlanChunkSizes(resourceRequests.TotalContentLength(), throughputs), o.IsResumeEnabled, o.RetryPolicy)
+
+		// The fastest downloaders come first in the queue and take the largest segments
+		sort.SliceStable(downloaders, func(i, j int) bool {
+			return throughputs[downloaders[i]] > throughputs[downloaders[j]]
+		})
+		for _, dwn := range downloaders {
+			telemetry.ReportDownloaderThroughput(dwn, throughputs[dwn])
+		}
+	} else {
+		resources = resourceRequests.ToResources(chunkSize, o.IsResumeEnabled, o.RetryPolicy)
+	}
+
+	/////////////////////////
+	/// Collect the segments, they are ordered by the scheduler
+	/////////////////////////
+
+	segments := []*ResourceSegment{}
+
+	for _, resource := range resources {
+		segments = append(segments, resource._segments...)
+	}
+
+	scheduler, _ := NewScheduler(o.Scheduler, throughputs)
+
+	var tuner *ConnectionTuner = nil
+	if o.IsAutoConnectionsEnabled {
+		tuner = NewConnectionTuner(downloaders)
+	}
+
+	/////////////////////////
+	/// Download the segments
+	/////////////////////////
+
+	telemetry.Start(&downloaders, &resourceRequests, &resources, &segments)
+
+	stopCheckpoint := CheckpointResources(resources, time.Second)
+
+	downloaders.Download(ctx, &segments, DownloadOptions{isEndgameEnabled: o.IsEndgameEnabled, scheduler: scheduler, tuner: tuner})
+
+	stopCheckpoint()
+
+	isInterrupted := ctx.Err() != nil
+	suspended := []*Resource{}
+	for _, resource := range resources {
+		if isInterrupted || resource.IsCancelled() {
+			suspended = append(suspended, resource)
+		}
+	}
+	SuspendResources(suspended)
+
+	telemetry.End()
+
+	/////////////////////////
+	/// Print the report
+	/////////////////////////
+
+	if isInterrupted {
+		fmt.Fprintln(out, "\n\nDownload interrupted, run again with -resume to continue")
+	} else {
+		fmt.Fprintln(out, "\n\nDownload completed")
+	}
+
+	telemetry.PrintReport(out)
+
+	return NewSummary(allResourceRequests, resources, isInterrupted), nil
+}
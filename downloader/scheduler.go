@@ -0,0 +1,624 @@
+package downloader
+
+import (
+	"container/heap"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+SchedulerEvents wakes up the idle scheduler when something happens which may give it new work, e.g. a segment is
+returned to the pending queue, a segment is settled, or a proxy is tripped or readmitted. It also counts the
+unsettled segments, so the scheduler knows when to stop without scanning all segments.
+*/
+type SchedulerEvents struct {
+	mutex      sync.Mutex
+	cond       *sync.Cond
+	version    uint64
+	unsettled  int
+	allSettled chan struct{}
+}
+
+func NewSchedulerEvents(segments []*ResourceSegment) *SchedulerEvents {
+	events := &SchedulerEvents{allSettled: make(chan struct{})}
+	events.cond = sync.NewCond(&events.mutex)
+
+	for _, seg := range segments {
+		if !seg.IsSettled() {
+			events.unsettled++
+		}
+	}
+	if events.unsettled == 0 {
+		close(events.allSettled)
+	}
+
+	return events
+}
+
+func (e *SchedulerEvents) Notify() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.version++
+	e.cond.Broadcast()
+}
+
+// ReportNewSegment is called when a segment is split from another segment.
+func (e *SchedulerEvents) ReportNewSegment() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.unsettled++
+}
+
+// ReportSegmentSettled is called when a segment is downloaded or failed and will not be downloaded again.
+func (e *SchedulerEvents) ReportSegmentSettled() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.unsettled--
+	if e.unsettled == 0 {
+		close(e.allSettled)
+	}
+
+	e.version++
+	e.cond.Broadcast()
+}
+
+// AllSettled returns a channel which is closed when all segments are settled.
+func (e *SchedulerEvents) AllSettled() <-chan struct{} {
+	return e.allSettled
+}
+
+func (e *SchedulerEvents) Version() uint64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.version
+}
+
+/*
+Wait blocks until something happens after the given version. It also returns once all segments are settled, the
+last segment may be settled before the scheduler reads the version and nothing would happen afterwards.
+*/
+func (e *SchedulerEvents) Wait(version uint64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for e.version == version && e.unsettled != 0 {
+		e.cond.Wait()
+	}
+}
+
+const SPLIT_MIN_REMAINING_LENGTH = 1024 * 10 // the segments with fewer remaining bytes are not worth splitting
+
+var SCHEDULER_NAMES = []string{"lpt", "round-robin", "bandwidth-proportional", "shortest-remaining-first"}
+
+/*
+Scheduler decides the order in which the segments are downloaded and which in-progress segment is split for an idle
+downloader. DownloaderCluster.Download calls it from a single goroutine, except Push and Finished which are called
+from the download goroutines.
+*/
+type Scheduler interface {
+	// Init receives all segments before the download starts, in the order of the request list.
+	Init(dc *DownloaderCluster, segments []*ResourceSegment)
+	// Push returns a segment to the pending segments, e.g. to retry it after a failure.
+	Push(seg *ResourceSegment)
+	PendingLen() int
+	// Next pops the pending segment the downloader should download next, or returns nil if there is none.
+	// The segments not allowed for the downloader, e.g. by ConnectionLimiter, are left pending.
+	Next(dwn *Downloader, isAllowed func(seg *ResourceSegment) bool) *ResourceSegment
+	// NextSplit picks the in-progress segment to split for the downloader and the ratio of its remaining bytes
+	// given to the downloader, or returns nil if no allowed segment is worth splitting.
+	NextSplit(dwn *Downloader, isAllowed func(seg *ResourceSegment) bool) (*ResourceSegment, float64)
+	// Started is called when the downloader starts downloading the segment.
+	Started(dwn *Downloader, seg *ResourceSegment)
+	// Finished is called when the download of the segment by the downloader returns, before the segment is pushed
+	// back for a retry.
+	Finished(dwn *Downloader, seg *ResourceSegment, result DownloadResult)
+}
+
+// NewScheduler returns the scheduler of the strategy. The throughputs measured in the planning phase can be nil.
+func NewScheduler(name string, throughputs map[*Downloader]float64) (Scheduler, error) {
+	switch name {
+	case "lpt":
+		return &LptScheduler{}, nil
+	case "round-robin":
+		return &RoundRobinScheduler{}, nil
+	case "bandwidth-proportional":
+		return NewBandwidthProportionalScheduler(throughputs), nil
+	case "shortest-remaining-first":
+		return &ShortestRemainingFirstScheduler{}, nil
+	default:
+		return nil, fmt.Errorf("unknown scheduler: %s, expected one of %s", name, strings.Join(SCHEDULER_NAMES, ", "))
+	}
+}
+
+// AllowAll allows every segment for every downloader, used when there is no connection limit.
+func AllowAll(seg *ResourceSegment) bool {
+	return true
+}
+
+func IsWorthSplitting(seg *ResourceSegment) bool {
+	return !seg.IsSettled() && seg.resource.IsAcceptRange() && seg.RemainingLength() > SPLIT_MIN_REMAINING_LENGTH
+}
+
+// SortByPriority sorts the segments by the priority of their resources from highest to lowest, then by less.
+func SortByPriority(segments []*ResourceSegment, less func(i, j *ResourceSegment) bool) {
+	sort.SliceStable(segments, func(i, j int) bool {
+		if segments[i].resource.priority != segments[j].resource.priority {
+			return segments[i].resource.priority > segments[j].resource.priority
+		}
+		return less(segments[i], segments[j])
+	})
+}
+
+// SegmentPool holds the pending segments and the in-progress segments which can be split, shared by the schedulers.
+type SegmentPool struct {
+	dc         *DownloaderCluster
+	pending    ThreadSafeQueue[ResourceSegment]
+	splittable ThreadSafeQueue[ResourceSegment]
+}
+
+func (p *SegmentPool) init(dc *DownloaderCluster, segments []*ResourceSegment) {
+	p.dc = dc
+	for _, seg := range segments {
+		putSeg := seg
+		p.pending.Push(putSeg)
+	}
+}
+
+func (p *SegmentPool) Push(seg *ResourceSegment) {
+	p.pending.Push(seg)
+}
+
+func (p *SegmentPool) PendingLen() int {
+	return p.pending.Len()
+}
+
+// Next pops the pending segments in order, see DownloaderCluster.PopPendingSegment for the retries.
+func (p *SegmentPool) Next(dwn *Downloader, isAllowed func(seg *ResourceSegment) bool) *ResourceSegment {
+	return p.dc.PopPendingSegment(dwn, &p.pending, isAllowed)
+}
+
+// Started makes the segment splittable, the speculative duplicates and the segments not accepting ranges are not.
+func (p *SegmentPool) Started(dwn *Downloader, seg *ResourceSegment) {
+	if seg.resource.IsAcceptRange() && !seg.IsSpeculative() {
+		p.splittable.Push(seg)
+	}
+}
+
+func (p *SegmentPool) Finished(dwn *Downloader, seg *ResourceSegment, result DownloadResult) {
+	p.splittable.Remove(seg)
+}
+
+/*
+popSplittable pops the best allowed splittable segment according to better, dropping the segments no longer worth
+splitting on the way. A popped segment is not split again, but its second half is splittable once it is started.
+*/
+func (p *SegmentPool) popSplittable(better func(i, j *ResourceSegment) bool, isAllowed func(seg *ResourceSegment) bool) *ResourceSegment {
+	for {
+		var best *ResourceSegment = nil
+		for _, seg := range p.splittable.Items() {
+			if !isAllowed(seg) {
+				continue
+			}
+			if best == nil || better(seg, best) {
+				best = seg
+			}
+		}
+		if best == nil {
+			return nil
+		}
+
+		p.splittable.Remove(best)
+		if IsWorthSplitting(best) {
+			return best
+		}
+	}
+}
+
+/*
+LptScheduler is the longest processing time first strategy: the largest segments are downloaded first and the
+largest in-progress segment is split in half, to balance the load among the downloaders.
+*/
+type LptScheduler struct {
+	SegmentPool
+}
+
+func (s *LptScheduler) Init(dc *DownloaderCluster, segments []*ResourceSegment) {
+	sorted := append([]*ResourceSegment{}, segments...)
+	SortByPriority(sorted, func(i, j *ResourceSegment) bool {
+		return i.ContentLength() > j.ContentLength()
+	})
+	s.init(dc, sorted)
+}
+
+func (s *LptScheduler) NextSplit(dwn *Downloader, isAllowed func(seg *ResourceSegment) bool) (*ResourceSegment, float64) {
+	return s.popSplittable(func(i, j *ResourceSegment) bool {
+		return i.ContentLength() > j.ContentLength()
+	}, isAllowed), 0.5
+}
+
+/*
+RoundRobinScheduler hands out one segment of every resource in turn, in the order of the request list regardless of
+their sizes, so all resources make progress together. The resources of a higher priority still take their turns
+first. The in-progress segments are split in half in the order they were started.
+
+The pending segments are kept per resource instead of the queue of the pool, so a turn only looks at the segments of
+the resource it takes from.
+*/
+type RoundRobinScheduler struct {
+	SegmentPool
+	resources  []*Resource // in the order of the request list, the resources of a higher priority first
+	pending    map[*Resource]*ThreadSafeQueue[ResourceSegment]
+	pendingLen int
+	turn       int // the index of the resource to take the next segment from
+	mutex      sync.Mutex
+}
+
+func (s *RoundRobinScheduler) Init(dc *DownloaderCluster, segments []*ResourceSegment) {
+	s.init(dc, nil)
+
+	sorted := append([]*ResourceSegment{}, segments...)
+	SortByPriority(sorted, func(i, j *ResourceSegment) bool {
+		return false
+	})
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.resources = []*Resource{}
+	s.pending = make(map[*Resource]*ThreadSafeQueue[ResourceSegment])
+	for _, seg := range sorted {
+		if _, ok := s.pending[seg.resource]; !ok {
+			s.resources = append(s.resources, seg.resource)
+			s.pending[seg.resource] = &ThreadSafeQueue[ResourceSegment]{}
+		}
+		s.pending[seg.resource].Push(seg)
+		s.pendingLen++
+	}
+}
+
+func (s *RoundRobinScheduler) Push(seg *ResourceSegment) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	queue, ok := s.pending[seg.resource]
+	if !ok {
+		// after the resources of the same priority, the resources of a priority stay next to each other
+		idx := slices.IndexFunc(s.resources, func(r *Resource) bool {
+			return r.priority < seg.resource.priority
+		})
+		if idx < 0 {
+			idx = len(s.resources)
+		}
+		s.resources = slices.Insert(s.resources, idx, seg.resource)
+		if idx < s.turn {
+			s.turn++
+		}
+		queue = &ThreadSafeQueue[ResourceSegment]{}
+		s.pending[seg.resource] = queue
+	}
+	queue.Push(seg)
+	s.pendingLen++
+}
+
+func (s *RoundRobinScheduler) PendingLen() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.pendingLen
+}
+
+/*
+Next takes a segment of the resource after the one of the last segment, the resources with nothing allowed are
+skipped. The resources of the same priority are next to each other, each priority is tried in turn from the highest.
+*/
+func (s *RoundRobinScheduler) Next(dwn *Downloader, isAllowed func(seg *ResourceSegment) bool) *ResourceSegment {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for first := 0; first < len(s.resources); {
+		end := first
+		for end < len(s.resources) && s.resources[end].priority == s.resources[first].priority {
+			end++
+		}
+
+		start := first
+		if s.turn >= first && s.turn < end {
+			start = s.turn
+		}
+		for i := 0; i < end-first; i++ {
+			idx := first + (start-first+i)%(end-first)
+			queue := s.pending[s.resources[idx]]
+			if queue.Len() == 0 {
+				continue
+			}
+			if seg := s.dc.PopPendingSegment(dwn, queue, isAllowed); seg != nil {
+				s.turn = (idx + 1) % len(s.resources)
+				s.pendingLen--
+				return seg
+			}
+		}
+
+		first = end
+	}
+	return nil
+}
+
+func (s *RoundRobinScheduler) NextSplit(dwn *Downloader, isAllowed func(seg *ResourceSegment) bool) (*ResourceSegment, float64) {
+	return s.popSplittable(func(i, j *ResourceSegment) bool {
+		return false
+	}, isAllowed), 0.5
+}
+
+/*
+BandwidthProportionalScheduler divides the work proportionally to the throughput of the downloaders. The largest
+segments are downloaded first like LptScheduler, but the segment with the longest estimated remaining time is split,
+and the idle downloader takes the share of the remaining bytes matching its throughput against the throughput of the
+current download, so both halves are expected to finish at the same time.
+
+The throughput of a downloader is measured from its finished downloads, starting from the throughput measured in the
+planning phase if any. An unknown throughput is assumed to be the average.
+*/
+type BandwidthProportionalScheduler struct {
+	SegmentPool
+	throughputs map[*Downloader]float64 // in bytes per second
+	mutex       sync.Mutex
+}
+
+func NewBandwidthProportionalScheduler(throughputs map[*Downloader]float64) *BandwidthProportionalScheduler {
+	s := &BandwidthProportionalScheduler{throughputs: make(map[*Downloader]float64)}
+	for dwn, throughput := range throughputs {
+		if throughput > 0 {
+			s.throughputs[dwn] = throughput
+		}
+	}
+	return s
+}
+
+func (s *BandwidthProportionalScheduler) Init(dc *DownloaderCluster, segments []*ResourceSegment) {
+	sorted := append([]*ResourceSegment{}, segments...)
+	SortByPriority(sorted, func(i, j *ResourceSegment) bool {
+		return i.ContentLength() > j.ContentLength()
+	})
+	s.init(dc, sorted)
+}
+
+// Throughput returns the measured throughput of the downloader, the average if unknown, or 0 if nothing is measured.
+func (s *BandwidthProportionalScheduler) Throughput(dwn *Downloader) float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if throughput, ok := s.throughputs[dwn]; ok {
+		return throughput
+	}
+	if len(s.throughputs) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, throughput := range s.throughputs {
+		sum += throughput
+	}
+	return sum / float64(len(s.throughputs))
+}
+
+func (s *BandwidthProportionalScheduler) NextSplit(dwn *Downloader, isAllowed func(seg *ResourceSegment) bool) (*ResourceSegment, float64) {
+	seg := s.popSplittable(func(i, j *ResourceSegment) bool {
+		return i.EstimatedRemainingTime() > j.EstimatedRemainingTime()
+	}, isAllowed)
+	if seg == nil {
+		return nil, 0
+	}
+
+	// the throughput of the current download is preferred, it is measured under the current load
+	current := seg.Throughput()
+	if other := seg.Downloader(); current == 0 && other != nil {
+		current = s.Throughput(other)
+	}
+	idle := s.Throughput(dwn)
+
+	if current <= 0 || idle <= 0 {
+		return seg, 0.5
+	}
+	return seg, idle / (idle + current)
+}
+
+func (s *BandwidthProportionalScheduler) Finished(dwn *Downloader, seg *ResourceSegment, result DownloadResult) {
+	s.SegmentPool.Finished(dwn, seg, result)
+
+	received := seg.Received()
+	elapsed := time.Since(seg._startTime).Seconds()
+	if received == 0 || elapsed <= 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// the moving average smooths out the variance between the downloads
+	throughput := float64(received) / elapsed
+	if old, ok := s.throughputs[dwn]; ok {
+		throughput = (old + throughput) / 2
+	}
+	s.throughputs[dwn] = throughput
+}
+
+/*
+ShortestRemainingFirstScheduler downloads the resource with the fewest remaining bytes first, so the resources are
+completed one by one as early as possible instead of all at the end. The segments of the same resource are
+downloaded from the beginning, and the splits also go to the resource closest to completion.
+
+The pending segments are kept per resource in a heap of the resources instead of the queue of the pool. The remaining
+bytes of a resource are cached and only updated when a download of the resource returns.
+*/
+type ShortestRemainingFirstScheduler struct {
+	SegmentPool
+	resources  map[*Resource]*PendingResource
+	heap       PendingResourceHeap // the resources with pending segments
+	pendingLen int
+	mutex      sync.Mutex
+}
+
+// PendingResource holds the pending segments of a resource in ShortestRemainingFirstScheduler.
+type PendingResource struct {
+	resource  *Resource
+	order     int                              // the position in the request list, breaks the ties
+	remaining uint64                           // the cached RemainingLength of the resource
+	pending   ThreadSafeQueue[ResourceSegment] // from the beginning of the resource
+	index     int                              // the index in the heap, -1 if nothing is pending
+}
+
+// PendingResourceHeap is a container/heap of the resources, the highest priority and the fewest remaining bytes first.
+type PendingResourceHeap []*PendingResource
+
+func (h PendingResourceHeap) Len() int {
+	return len(h)
+}
+
+func (h PendingResourceHeap) Less(i, j int) bool {
+	if h[i].resource.priority != h[j].resource.priority {
+		return h[i].resource.priority > h[j].resource.priority
+	}
+	if h[i].remaining != h[j].remaining {
+		return h[i].remaining < h[j].remaining
+	}
+	return h[i].order < h[j].order
+}
+
+func (h PendingResourceHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *PendingResourceHeap) Push(x any) {
+	pr := x.(*PendingResource)
+	pr.index = len(*h)
+	*h = append(*h, pr)
+}
+
+func (h *PendingResourceHeap) Pop() any {
+	old := *h
+	pr := old[len(old)-1]
+	old[len(old)-1] = nil
+	pr.index = -1
+	*h = old[:len(old)-1]
+	return pr
+}
+
+func (s *ShortestRemainingFirstScheduler) Init(dc *DownloaderCluster, segments []*ResourceSegment) {
+	s.init(dc, nil)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.resources = make(map[*Resource]*PendingResource)
+	for _, seg := range segments {
+		s.pendingResource(seg.resource)
+	}
+	for _, seg := range segments {
+		s.push(seg)
+	}
+}
+
+// pendingResource returns the pending segments of the resource, the caller must hold the mutex.
+func (s *ShortestRemainingFirstScheduler) pendingResource(r *Resource) *PendingResource {
+	pr, ok := s.resources[r]
+	if !ok {
+		pr = &PendingResource{resource: r, order: len(s.resources), remaining: r.RemainingLength(), index: -1}
+		s.resources[r] = pr
+	}
+	return pr
+}
+
+func (s *ShortestRemainingFirstScheduler) push(seg *ResourceSegment) {
+	pr := s.pendingResource(seg.resource)
+	pr.pending.Push(seg)
+	pr.pending.Sort(func(i, j *ResourceSegment) bool {
+		return i.from < j.from
+	})
+	s.pendingLen++
+
+	if pr.index < 0 {
+		heap.Push(&s.heap, pr)
+	}
+}
+
+func (s *ShortestRemainingFirstScheduler) Push(seg *ResourceSegment) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.push(seg)
+}
+
+func (s *ShortestRemainingFirstScheduler) PendingLen() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.pendingLen
+}
+
+// Next takes a segment of the resource closest to completion, the resources with nothing allowed are skipped.
+func (s *ShortestRemainingFirstScheduler) Next(dwn *Downloader, isAllowed func(seg *ResourceSegment) bool) *ResourceSegment {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	skipped := []*PendingResource{}
+	defer func() {
+		for _, pr := range skipped {
+			heap.Push(&s.heap, pr)
+		}
+	}()
+
+	for s.heap.Len() != 0 {
+		pr := heap.Pop(&s.heap).(*PendingResource)
+		seg := s.dc.PopPendingSegment(dwn, &pr.pending, isAllowed)
+		if pr.pending.Len() != 0 {
+			skipped = append(skipped, pr)
+		}
+		if seg != nil {
+			s.pendingLen--
+			return seg
+		}
+	}
+	return nil
+}
+
+// Remaining returns the cached number of remaining bytes of the resource.
+func (s *ShortestRemainingFirstScheduler) Remaining(r *Resource) uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.pendingResource(r).remaining
+}
+
+// NextSplit splits the resource closest to completion, the segments of the same resource in the order they were started.
+func (s *ShortestRemainingFirstScheduler) NextSplit(dwn *Downloader, isAllowed func(seg *ResourceSegment) bool) (*ResourceSegment, float64) {
+	return s.popSplittable(func(i, j *ResourceSegment) bool {
+		if i.resource.priority != j.resource.priority {
+			return i.resource.priority > j.resource.priority
+		}
+		return s.Remaining(i.resource) < s.Remaining(j.resource)
+	}, isAllowed), 0.5
+}
+
+// Finished updates the cached remaining bytes of the resource, which moves the resource in the heap.
+func (s *ShortestRemainingFirstScheduler) Finished(dwn *Downloader, seg *ResourceSegment, result DownloadResult) {
+	s.SegmentPool.Finished(dwn, seg, result)
+	remaining := seg.resource.RemainingLength()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pr := s.pendingResource(seg.resource)
+	pr.remaining = remaining
+	if pr.index >= 0 {
+		heap.Fix(&s.heap, pr.index)
+	}
+}
@@ -0,0 +1,230 @@
+package downloader
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var socks5ReplyMessages = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// the replies classified like the errors of the direct connections, see ClassifyError
+var socks5ReplyErrors = map[byte]error{
+	0x03: syscall.ENETUNREACH,
+	0x04: syscall.EHOSTUNREACH,
+	0x05: syscall.ECONNREFUSED,
+}
+
+/*
+Socks5Dialer connects to the destination through a SOCKS5 proxy (RFC 1928), e.g. an SSH dynamic forward.
+The host name of the destination is resolved by the proxy, and the username/password authentication (RFC 1929)
+is used if the proxy url has credentials. The timeout covers both the connection to the proxy and the handshake.
+*/
+type Socks5Dialer struct {
+	proxyAddress string
+	username     string
+	password     string
+	hasAuth      bool
+	timeout      time.Duration // 0 means no timeout
+	dialer       net.Dialer
+}
+
+func NewSocks5Dialer(proxyUrl *url.URL, timeout time.Duration) *Socks5Dialer {
+	d := &Socks5Dialer{proxyAddress: proxyUrl.Host, timeout: timeout}
+	if proxyUrl.User != nil {
+		d.username = proxyUrl.User.Username()
+		d.password, _ = proxyUrl.User.Password()
+		d.hasAuth = true
+	}
+	return d
+}
+
+func (d *Socks5Dialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	parentCtx := ctx
+	if d.timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	conn, err := d.dialer.DialContext(ctx, "tcp", d.proxyAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	// the handshake must not outlive the context
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	handshakeDone := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-handshakeDone:
+		}
+	}()
+
+	err = d.handshake(conn, address)
+	close(handshakeDone)
+	<-watcherDone
+
+	if err != nil {
+		conn.Close()
+		if parentCtx.Err() != nil {
+			return nil, parentCtx.Err()
+		}
+		if ctx.Err() != nil {
+			// classified as a dial timeout like the connection to the proxy
+			return nil, &net.OpError{Op: "dial", Net: network, Err: ctx.Err()}
+		}
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (d *Socks5Dialer) handshake(conn net.Conn, address string) error {
+	host, rawPort, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(rawPort, 10, 16)
+	if err != nil {
+		return fmt.Errorf("socks5 invalid port: %s", rawPort)
+	}
+
+	// greeting, the methods are no authentication and username/password
+	methods := []byte{0x00}
+	if d.hasAuth {
+		methods = []byte{0x00, 0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("socks5 unexpected protocol version: %d", reply[0])
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if !d.hasAuth {
+			return fmt.Errorf("socks5 proxy requires authentication")
+		}
+		if len(d.username) > 255 || len(d.password) > 255 {
+			return fmt.Errorf("socks5 username or password is too long")
+		}
+		auth := []byte{0x01, byte(len(d.username))}
+		auth = append(auth, d.username...)
+		auth = append(auth, byte(len(d.password)))
+		auth = append(auth, d.password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("socks5 authentication failed")
+		}
+	default:
+		return fmt.Errorf("socks5 no acceptable authentication method")
+	}
+
+	// connect request
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(req, 0x01)
+		req = append(req, ip.To4()...)
+	} else if ip != nil {
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("socks5 host name is too long: %s", host)
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		if replyErr, ok := socks5ReplyErrors[header[1]]; ok {
+			return fmt.Errorf("socks5 %s connect: %w", address, replyErr)
+		}
+		message, ok := socks5ReplyMessages[header[1]]
+		if !ok {
+			message = "unknown error " + strconv.Itoa(int(header[1]))
+		}
+		return fmt.Errorf("socks5 %s connect: %s", address, message)
+	}
+
+	// skip the bound address and port
+	boundLength := 0
+	switch header[3] {
+	case 0x01:
+		boundLength = net.IPv4len + 2
+	case 0x04:
+		boundLength = net.IPv6len + 2
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		boundLength = int(length[0]) + 2
+	default:
+		return fmt.Errorf("socks5 unexpected address type: %d", header[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, boundLength)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type Socks5DownloaderClientImpl http.Client
+
+func NewSocks5DownloaderClient(proxyUrl *url.URL, timeouts *Timeouts) *Socks5DownloaderClientImpl {
+	transport := timeouts.NewTransport()
+	transport.DialContext = NewSocks5Dialer(proxyUrl, timeouts.dial).DialContext // set proxy
+
+	client := &Socks5DownloaderClientImpl{}
+	client.Transport = transport
+	return client
+}
+
+func (client *Socks5DownloaderClientImpl) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return (*http.Client)(client).Do(req.WithContext(ctx))
+}
@@ -0,0 +1,179 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// The exit codes of the command line tool, a usage error is 2 like the flag package, e.g. the error of Run.
+const (
+	EXIT_SUCCESS           = 0 // every requested resource is downloaded
+	EXIT_PARTIAL_FAILURE   = 1 // some resources are unavailable or failed to download
+	EXIT_USAGE_ERROR       = 2
+	EXIT_PREFLIGHT_FAILURE = 3   // nothing is downloaded, no resource is available or the download is aborted
+	EXIT_INTERRUPTED       = 130 // the download is interrupted by a signal, it can be resumed
+)
+
+// The actions when some resources are not available in the preflight, see -onUnavailable.
+const (
+	ON_UNAVAILABLE_PROMPT = "prompt" // ask whether to continue with the available resources
+	ON_UNAVAILABLE_SKIP   = "skip"   // continue with the available resources
+	ON_UNAVAILABLE_ABORT  = "abort"  // download nothing
+)
+
+var resourceRequestStatusNames = map[ResourceRequestStatus]string{
+	AVAILABLE:          "available",
+	NOT_FOUND:          "not-found",
+	CONNECTION_TIMEOUT: "connection-timeout",
+	CONNECTION_REFUSED: "connection-refused",
+	SIZE_MISMATCH:      "size-mismatch",
+	CONNECTION_FAILED:  "connection-failed",
+}
+
+func (status ResourceRequestStatus) String() string {
+	return resourceRequestStatusNames[status]
+}
+
+/*
+SummaryResource is the outcome of a requested resource, the status is one of:
+  - downloaded: the resource is downloaded and verified
+  - failed: the download failed, see the reason
+  - unavailable: the resource is not available in the preflight, see the reason
+  - skipped: the resource is available, but nothing is downloaded because of the unavailable resources
+  - interrupted: the download is interrupted or the resource is cancelled before it is completed, it can be resumed
+*/
+type SummaryResource struct {
+	Url           string `json:"url"`
+	Dest          string `json:"dest"`
+	Status        string `json:"status"`
+	Reason        string `json:"reason,omitempty"`
+	ContentLength uint64 `json:"contentLength"`
+}
+
+// Summary is the machine-readable result of the execution, written as JSON with -summary.
+type Summary struct {
+	ExitCode      int               `json:"exitCode"`
+	IsInterrupted bool              `json:"interrupted"`
+	Resources     []SummaryResource `json:"resources"`
+}
+
+/*
+NewSummary summarizes the resource requests in the order of the request list. The resources are created from the
+available requests in the same order, nil if the download has not started. The exit code is EXIT_SUCCESS only if
+every requested resource is downloaded, EXIT_INTERRUPTED if the preflight or the download is interrupted, or
+EXIT_PREFLIGHT_FAILURE if the download has not started otherwise.
+*/
+func NewSummary(allRequests ResourceRequestList, resources []*Resource, isInterrupted bool) Summary {
+	summary := Summary{ExitCode: EXIT_SUCCESS, IsInterrupted: isInterrupted, Resources: []SummaryResource{}}
+
+	resourceIdx := 0
+	for _, rr := range allRequests {
+		sr := SummaryResource{Url: rr.url, Dest: rr.dest, ContentLength: rr.contentLength}
+
+		if rr.status != AVAILABLE {
+			sr.Status = "unavailable"
+			sr.Reason = rr.UnavailableReason()
+		} else if resources == nil {
+			sr.Status = "skipped"
+		} else {
+			r := resources[resourceIdx]
+			resourceIdx++
+
+			sr.ContentLength = r.contentLength
+			if r.Status() == DOWNLOADED {
+				sr.Status = "downloaded"
+			} else if (isInterrupted || r.IsCancelled()) && r.IsResumable() {
+				sr.Status = "interrupted"
+			} else {
+				sr.Status = "failed"
+				sr.Reason = r.FailureReason()
+			}
+		}
+
+		if sr.Status != "downloaded" {
+			summary.ExitCode = EXIT_PARTIAL_FAILURE
+		}
+		summary.Resources = append(summary.Resources, sr)
+	}
+
+	if isInterrupted {
+		summary.ExitCode = EXIT_INTERRUPTED
+	} else if resources == nil {
+		summary.ExitCode = EXIT_PREFLIGHT_FAILURE
+	}
+
+	return summary
+}
+
+// UnavailableReason tells why the resource is not available, e.g. 'connection-failed: dns, origin fault'.
+func (rr *ResourceRequest) UnavailableReason() string {
+	reason := rr.status.String()
+	if rr.status == SIZE_MISMATCH {
+		return fmt.Sprintf("%s: expected %d, got %d", reason, *rr.expectedSize, rr.contentLength)
+	}
+
+	reason += ": " + rr.errorClass.String()
+	switch rr.fault {
+	case ORIGIN_FAULT:
+		reason += ", origin fault"
+	case UNKNOWN_FAULT:
+		reason += ", unknown fault"
+	}
+	return reason
+}
+
+// FailureReason tells why the resource failed to download, e.g. 'checksum-mismatch' or 'segment-failed: timeout'.
+func (r *Resource) FailureReason() string {
+	if r.IsChanged() {
+		return "changed-on-server"
+	}
+	if r.IsCorrupted() && r._actualChecksum.IsEmpty() {
+		return "checksum-unverified"
+	}
+	if r.IsCorrupted() {
+		return "checksum-mismatch"
+	}
+
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	for _, seg := range r._segments {
+		if seg.status == DOWNLOAD_FAILED && seg._failures != nil {
+			return "segment-failed: " + seg._lastError.String()
+		}
+	}
+	return "segment-failed"
+}
+
+// IsResumable returns true if the resource is not failed and has pending segments, e.g. after an interrupt.
+func (r *Resource) IsResumable() bool {
+	if r.IsChanged() || r.IsCorrupted() {
+		return false
+	}
+
+	r._mutex.Lock()
+	defer r._mutex.Unlock()
+
+	for _, seg := range r._segments {
+		if seg.status == PENDING {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the summary as JSON to the path, or to the standard output if the path is '-'.
+func (s Summary) Write(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
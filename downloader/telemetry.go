@@ -0,0 +1,613 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tm "github.com/buger/goterm"
+	cc "github.com/crazy3lf/colorconv"
+)
+
+type TelemetryProgressBarColor struct {
+	fr, fg, fb, br, bg, bb uint8
+}
+
+type TelemetryResourceSegmentRuntime struct {
+	rs          *ResourceSegment
+	ttl         uint8
+	startTime   time.Time
+	settledTime time.Time
+}
+
+type Telemetry struct {
+	name                      string // Used in the time log file
+	logFile                   *os.File
+	timeLogFile               *os.File
+	downloaders               *DownloaderCluster
+	requests                  *ResourceRequestList
+	resources                 *[]*Resource
+	segments                  *[]*ResourceSegment
+	downloadersIndexMap       map[*Downloader]int
+	resourceColorMap          map[*Resource]float64
+	resourceIdMap             map[*Resource]uint
+	segmentIdMap              map[*ResourceSegment]uint
+	resourceSegmentCountMap   map[*Resource]uint
+	downloaderSegmentMapMutex sync.Mutex // guards downloaderSegmentMap and the segment IDs, the segments are split during the download
+	downloaderSegmentMap      map[*Downloader][]*TelemetryResourceSegmentRuntime
+	downloaderIpMap           map[*Downloader]string
+	downloaderThroughputMap   map[*Downloader]float64 // measured in the planning phase, in bytes per second
+	proxyConnectionLimitMap   map[*Proxy]int          // the number of connections tuned by ConnectionTuner
+	throttleCountMutex        sync.Mutex
+	downloaderThrottleCount   map[*Downloader]int // the number of 429 and 503 responses
+	stallCountMutex           sync.Mutex
+	downloaderStallCount      map[*Downloader]int // the number of segments stalled by the idle-read timeout
+	totalContentLength        uint64
+	chunkSize                 uint64
+	logger                    *log.Logger   // discarded unless a log file is given to Init
+	progress                  io.Writer     // the progress bars are drawn on it, nil if not shown
+	screen                    bytes.Buffer  // the progress bars being drawn
+	updateStop                chan struct{} // closed to stop drawing the progress bars
+	updateDone                chan struct{} // closed once the progress bars are no longer drawn
+	startTime                 time.Time
+	endTime                   time.Time
+}
+
+// the log of the downloaders, resources and segments without telemetry
+var discardLogger = log.New(io.Discard, "", log.LstdFlags)
+
+/*
+NewTelemetry creates the telemetry of one Run. The downloaders created with it report to it and log to its log, so
+do the resources fetched by them and their segments. Nothing is reported or logged by the ones without telemetry.
+*/
+func NewTelemetry() *Telemetry {
+	return &Telemetry{
+		downloaderIpMap:         make(map[*Downloader]string),
+		downloaderThroughputMap: make(map[*Downloader]float64),
+		proxyConnectionLimitMap: make(map[*Proxy]int),
+		downloaderThrottleCount: make(map[*Downloader]int),
+		downloaderStallCount:    make(map[*Downloader]int),
+		downloaderSegmentMap:    make(map[*Downloader][]*TelemetryResourceSegmentRuntime),
+		segmentIdMap:            make(map[*ResourceSegment]uint),
+		resourceSegmentCountMap: make(map[*Resource]uint),
+		logger:                  log.New(io.Discard, "", log.LstdFlags)}
+}
+
+// Logger returns the log of the telemetry, it is discarded if the telemetry is nil.
+func (tel *Telemetry) Logger() *log.Logger {
+	if tel == nil {
+		return discardLogger
+	}
+	return tel.logger
+}
+
+// Init opens the log files, the progress bars are drawn on the progress writer during the download unless it is nil.
+func (tel *Telemetry) Init(logFilePathRaw string, name string, timeLogFilePathRaw string, progress io.Writer) error {
+	if logFilePathRaw != "" {
+		os.MkdirAll(filepath.Dir(logFilePathRaw), os.ModePerm)
+		f, err := os.OpenFile(logFilePathRaw, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return fmt.Errorf("error opening file: %v", err)
+		}
+
+		tel.logFile = f
+		tel.logger.SetOutput(f)
+	}
+
+	tel.name = name
+
+	if timeLogFilePathRaw != "" {
+		os.MkdirAll(filepath.Dir(timeLogFilePathRaw), os.ModePerm)
+		f, err := os.OpenFile(timeLogFilePathRaw, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			tel.Close()
+			return fmt.Errorf("error opening file: %v", err)
+		}
+
+		tel.timeLogFile = f
+	} else {
+		f, err := os.OpenFile("/dev/null", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			tel.Close()
+			return fmt.Errorf("error opening file: %v", err)
+		}
+
+		tel.timeLogFile = f
+	}
+
+	tel.progress = progress
+
+	return nil
+}
+
+// Close closes the log files opened by Init, the log is discarded afterwards.
+func (tel *Telemetry) Close() {
+	tel.logger.SetOutput(io.Discard)
+
+	if tel.logFile != nil {
+		tel.logFile.Close()
+		tel.logFile = nil
+	}
+	if tel.timeLogFile != nil {
+		tel.timeLogFile.Close()
+		tel.timeLogFile = nil
+	}
+}
+
+func (tel *Telemetry) Start(
+	downloaders *DownloaderCluster,
+	requests *ResourceRequestList,
+	resources *[]*Resource,
+	segments *[]*ResourceSegment) {
+	tel.downloaders = downloaders
+	tel.requests = requests
+	tel.resources = resources
+	tel.segments = segments
+	tel.downloadersIndexMap = make(map[*Downloader]int)
+	tel.resourceColorMap = make(map[*Resource]float64)
+	tel.resourceIdMap = make(map[*Resource]uint)
+	tel.downloaderSegmentMapMutex.Lock()
+	defer tel.downloaderSegmentMapMutex.Unlock()
+	tel.totalContentLength = requests.TotalContentLength()
+	tel.chunkSize = uint64(math.Ceil(float64(tel.totalContentLength) / float64(len(*downloaders))))
+
+	for i, d := range *downloaders {
+		tel.downloadersIndexMap[d] = i
+	}
+
+	n := 0
+	idx := uint(0)
+	for _, r := range *resources {
+		tel.resourceColorMap[r] = float64(n)
+		tel.resourceIdMap[r] = idx
+		n = (n + 17) % 360
+		idx++
+	}
+
+	// segments restored from the journal which are already downloaded
+	for _, r := range *resources {
+		for _, rs := range r._writtenSegments {
+			tel.segmentIdMap[rs] = tel.resourceSegmentCountMap[rs.resource]
+			tel.resourceSegmentCountMap[rs.resource]++
+		}
+	}
+
+	for _, rs := range *segments {
+		tel.segmentIdMap[rs] = tel.resourceSegmentCountMap[rs.resource]
+		tel.resourceSegmentCountMap[rs.resource]++
+	}
+
+	if tel.progress != nil {
+		tel.updateStop = make(chan struct{})
+		tel.updateDone = make(chan struct{})
+		go func() {
+			defer close(tel.updateDone)
+			for {
+				tel.Update()
+				select {
+				case <-tel.updateStop:
+					return
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+		}()
+	}
+
+	tel.startTime = time.Now()
+}
+
+func (tel *Telemetry) End() {
+	tel.endTime = time.Now()
+
+	if tel.updateDone != nil {
+		close(tel.updateStop)
+		<-tel.updateDone
+	}
+
+	if tel.timeLogFile != nil {
+		time := float64(tel.endTime.Sub(tel.startTime).Milliseconds()) / 1000.0
+		timeStr := strconv.FormatFloat(time, 'f', -1, 64)
+		tel.timeLogFile.WriteString(fmt.Sprintf("%s, %s\n", tel.name, timeStr))
+	}
+}
+
+func (tel *Telemetry) Update() {
+	tel.downloaderSegmentMapMutex.Lock()
+	defer tel.downloaderSegmentMapMutex.Unlock()
+
+	tel.screen.Reset()
+	tel.screen.WriteString("\033[2J") // clear the screen
+	tel.MoveCursor(1, 1)
+
+	tel.screen.WriteString("Resources: ")
+
+	screenWdith := ReportWidth()
+	usableWidth := uint(screenWdith-11) - 2
+
+	for _, r := range *tel.resources {
+		tel.PrintResourceProgress(r, usableWidth)
+	}
+
+	tel.MoveCursor(1, 3)
+	tel.screen.WriteString("Downloaders: ")
+	for i, dwn := range *tel.downloaders {
+		tel.MoveCursor(1, i+4)
+		fmt.Fprintf(&tel.screen, "#%-2d - ", i)
+
+		arr := tel.downloaderSegmentMap[dwn]
+		for _, runtime := range arr {
+			rs := runtime.rs
+			color := GetTelemetryProgressBarColor(tel.resourceColorMap[rs.resource])
+			r := rs.resource
+
+			resourceBarWidth := tel.GetResourceBarWidth(r, usableWidth)
+			tel.PrintResourceSegmentProgress(rs, &color, resourceBarWidth)
+		}
+
+		if len(arr) != 0 {
+			lastRs := arr[len(arr)-1].rs
+			from, to, ack := lastRs.Progress()
+			pct := float64(ack-from) / float64(to-from)
+			info := fmt.Sprintf("Downloading %d_%d (%.2f%%)", tel.resourceIdMap[lastRs.resource], tel.segmentIdMap[lastRs], pct*100)
+			tel.MoveCursor(screenWdith-28, i+4)
+			fmt.Fprintf(&tel.screen, "%28s", info)
+		}
+	}
+
+	tel.Flush()
+}
+
+// MoveCursor moves the cursor of the progress bars to the column x and the row y, starting from 1.
+func (tel *Telemetry) MoveCursor(x int, y int) {
+	fmt.Fprintf(&tel.screen, "\033[%d;%dH", y, x)
+}
+
+// Flush writes the progress bars to the progress writer, the rows below the terminal are not written.
+func (tel *Telemetry) Flush() {
+	height := tm.Height()
+	for idx, str := range strings.SplitAfter(tel.screen.String(), "\n") {
+		if height > 0 && idx > height {
+			break
+		}
+		io.WriteString(tel.progress, str)
+	}
+	tel.screen.Reset()
+}
+
+func (tel *Telemetry) PrintReport(w io.Writer) {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "# Report")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "## Resources")
+	fmt.Fprintln(w)
+	for _, r := range *tel.resources {
+		fmt.Fprintf(w, "### Resources #%d\n", tel.resourceIdMap[r])
+
+		allSegs := r.Segments()
+
+		usableWidth := uint(ReportWidth() - 3)
+		remainingWidth := int(usableWidth)
+		fmt.Fprintln(w, "#### Info")
+		fmt.Fprintf(w, " - Url: %s\n", r.url)
+		fmt.Fprintf(w, " - Length: %d\n", r.contentLength)
+		fmt.Fprintf(w, " - Is Accept Range: %t\n", r.isAcceptRange)
+		if r.isStreaming {
+			fmt.Fprintln(w, " - Streaming: the length was unknown before the download")
+		}
+		if r.etag != "" {
+			fmt.Fprintf(w, " - ETag: %s\n", r.etag)
+		}
+		if r.lastModified != "" {
+			fmt.Fprintf(w, " - Last Modified: %s\n", r.lastModified)
+		}
+		if !r.checksum.IsEmpty() {
+			fmt.Fprintf(w, " - Checksum: %s\n", r.checksum)
+		}
+		if r.IsChanged() {
+			fmt.Fprintln(w, " - FAILED: changed on server")
+		}
+		if r.IsCorrupted() && r._actualChecksum.IsEmpty() {
+			fmt.Fprintln(w, " - FAILED: unable to verify checksum")
+		} else if r.IsCorrupted() {
+			fmt.Fprintf(w, " - FAILED: checksum mismatch, got %s\n", r._actualChecksum)
+		}
+		fmt.Fprintln(w, "#### Segments")
+		fmt.Fprintln(w, "```")
+		fmt.Fprint(w, "|")
+		for _, rs := range allSegs {
+			pct := float64(rs.ContentLength()) / float64(r.contentLength)
+			barWidth := int(math.Round(float64(usableWidth) * pct))
+			barWidth = max(min(barWidth, remainingWidth), 0)
+			remainingWidth -= barWidth
+			if barWidth > 1 {
+				fmt.Fprint(w, strings.Repeat("-", barWidth-1)+"|")
+			}
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "```")
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "## Downloaders")
+	fmt.Fprintln(w)
+	for _, dwn := range *tel.downloaders {
+		arr := tel.downloaderSegmentMap[dwn]
+		dwnIndex := tel.downloadersIndexMap[dwn]
+		fmt.Fprintf(w, "### Downloader #%d \n", dwnIndex)
+		fmt.Fprintln(w, "#### Segments")
+		totalRecived := uint64(0)
+		for _, runtime := range arr {
+			rs := runtime.rs
+			idStr := fmt.Sprintf("%d_%d", tel.resourceIdMap[rs.resource], tel.segmentIdMap[rs])
+			pct := float64(rs.ack-rs.from) / float64(rs.ContentLength())
+			fmt.Fprintf(w, " - Segment#%s range=%d~%d len=%d received=%d (%s %.2f%%)", idStr, rs.from, rs.to, rs.ContentLength(), rs.ack-rs.from, SignedInt(int64(rs.ack)-int64(rs.to)), pct*100)
+			if rs.IsSpeculative() {
+				fmt.Fprint(w, " SPECULATIVE")
+			}
+			if rs.IsSpeculative() && rs.status == DOWNLOAD_FAILED {
+				fmt.Fprint(w, " (lost the race or failed)")
+			} else if rs.status == DOWNLOAD_FAILED && rs.resource.IsChanged() {
+				fmt.Fprint(w, " FAILED (changed on server)")
+			} else if rs.status == DOWNLOAD_FAILED && rs._failures != nil {
+				fmt.Fprintf(w, " FAILED (ttl=%d, last error: %s)", rs.ttl, rs._lastError)
+			} else if rs.status == DOWNLOAD_FAILED {
+				fmt.Fprintf(w, " FAILED (ttl=%d)", rs.ttl)
+			}
+			fmt.Fprintln(w)
+
+			totalRecived += rs.ack - rs.from
+		}
+		fmt.Fprintln(w, "#### Summary")
+		if dwn.proxy != nil {
+			fmt.Fprintf(w, " - Proxy: %s (circuit breaker tripped %d times)\n", dwn.proxy.Address(), dwn.proxy.health.TripCount())
+		}
+		if count := tel.downloaderThrottleCount[dwn]; count != 0 {
+			fmt.Fprintf(w, " - Throttled: %d times (429 or 503)\n", count)
+		}
+		if count := tel.downloaderStallCount[dwn]; count != 0 {
+			fmt.Fprintf(w, " - Stalled: %d times (idle-read timeout)\n", count)
+		}
+		if throughput, ok := tel.downloaderThroughputMap[dwn]; ok {
+			fmt.Fprintf(w, " - Planned throughput: %.0f B/s\n", throughput)
+		}
+		fmt.Fprintf(w, " - Recived %d duty=%s\n", totalRecived, SignedInt(int64(totalRecived)-int64(tel.chunkSize)))
+		if len(arr) != 0 {
+			fmt.Fprintf(w, " - Time used: %dms\n", arr[len(arr)-1].settledTime.Sub(arr[0].startTime).Milliseconds())
+		}
+		fmt.Fprintln(w)
+	}
+	if len(tel.proxyConnectionLimitMap) != 0 {
+		fmt.Fprintln(w, "## Connection Tuning")
+		fmt.Fprintln(w)
+		lines := []string{}
+		for proxy, limit := range tel.proxyConnectionLimitMap {
+			lines = append(lines, fmt.Sprintf(" - Proxy: %s connections=%d", ProxyAddress(proxy), limit))
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Total number of segments: %d\n", len(*tel.segments))
+	totalThrottled := 0
+	for _, count := range tel.downloaderThrottleCount {
+		totalThrottled += count
+	}
+	fmt.Fprintf(w, "Total number of throttling responses: %d\n", totalThrottled)
+	totalStalled := 0
+	for _, count := range tel.downloaderStallCount {
+		totalStalled += count
+	}
+	fmt.Fprintf(w, "Total number of stalled downloads: %d\n", totalStalled)
+	fmt.Fprintln(w)
+}
+
+func (tel *Telemetry) ReportNewDownloaderAdded(dwn *Downloader, ip string) {
+	if tel == nil {
+		return
+	}
+
+	tel.downloaderIpMap[dwn] = ip
+}
+
+func (tel *Telemetry) ReportDownloaderThroughput(dwn *Downloader, throughput float64) {
+	if tel == nil {
+		return
+	}
+
+	tel.downloaderThroughputMap[dwn] = throughput
+}
+
+func (tel *Telemetry) ReportConnectionLimit(proxy *Proxy, limit int) {
+	if tel == nil {
+		return
+	}
+
+	tel.proxyConnectionLimitMap[proxy] = limit
+}
+
+func (tel *Telemetry) ReportThrottled(dwn *Downloader) {
+	if tel == nil {
+		return
+	}
+
+	tel.throttleCountMutex.Lock()
+	defer tel.throttleCountMutex.Unlock()
+
+	tel.downloaderThrottleCount[dwn]++
+}
+
+func (tel *Telemetry) ReportStalled(dwn *Downloader) {
+	if tel == nil {
+		return
+	}
+
+	tel.stallCountMutex.Lock()
+	defer tel.stallCountMutex.Unlock()
+
+	tel.downloaderStallCount[dwn]++
+}
+
+func (tel *Telemetry) GetDownloaderIp(dwn *Downloader) string {
+	if tel == nil {
+		return ""
+	}
+	return tel.downloaderIpMap[dwn]
+}
+
+// GetDownloaderIps returns the IPs of the downloaders separated by commas.
+func (tel *Telemetry) GetDownloaderIps(dwns []*Downloader) string {
+	ips := []string{}
+	for _, dwn := range dwns {
+		ips = append(ips, tel.GetDownloaderIp(dwn))
+	}
+	return strings.Join(ips, ", ")
+}
+
+func (tel *Telemetry) ReportNewSegmentAdded(rs *ResourceSegment) {
+	if tel == nil {
+		return
+	}
+
+	tel.downloaderSegmentMapMutex.Lock()
+	defer tel.downloaderSegmentMapMutex.Unlock()
+
+	tel.segmentIdMap[rs] = tel.resourceSegmentCountMap[rs.resource]
+	tel.resourceSegmentCountMap[rs.resource]++
+}
+
+func (tel *Telemetry) ReportDownloadingSegment(dwn *Downloader, rs *ResourceSegment) {
+	if tel == nil {
+		return
+	}
+
+	tel.downloaderSegmentMapMutex.Lock()
+	defer tel.downloaderSegmentMapMutex.Unlock()
+
+	if tel.downloaderSegmentMap[dwn] == nil {
+		tel.downloaderSegmentMap[dwn] = []*TelemetryResourceSegmentRuntime{{rs: rs, ttl: rs.ttl, startTime: time.Now()}}
+	} else {
+		tel.downloaderSegmentMap[dwn] = append(tel.downloaderSegmentMap[dwn], &TelemetryResourceSegmentRuntime{rs: rs, ttl: rs.ttl, startTime: time.Now()})
+	}
+}
+
+func (tel *Telemetry) ReportDownloadSettled(dwn *Downloader, rs *ResourceSegment) {
+	if tel == nil {
+		return
+	}
+
+	tel.downloaderSegmentMapMutex.Lock()
+	defer tel.downloaderSegmentMapMutex.Unlock()
+
+	arr := tel.downloaderSegmentMap[dwn]
+	for _, runtime := range arr {
+		if runtime.rs == rs {
+			// dereference and copy
+			rs.resource._mutex.Lock()
+			runtime.rs = &ResourceSegment{
+				resource:  rs.resource,
+				from:      rs.from,
+				to:        rs.to,
+				ack:       rs.ack,
+				ttl:       rs.ttl,
+				status:    rs.status,
+				_original: rs._original}
+			rs.resource._mutex.Unlock()
+			runtime.settledTime = time.Now()
+			break
+		}
+	}
+}
+
+// GetResourceBarWidth returns the width of the resource in the progress bar, the streaming resources are not in the total length.
+func (tel *Telemetry) GetResourceBarWidth(r *Resource, usableWidth uint) uint {
+	if tel.totalContentLength == 0 || r.isStreaming {
+		return 0
+	}
+	return min(uint(math.Round(float64(usableWidth)*float64(r.contentLength)/float64(tel.totalContentLength))), usableWidth)
+}
+
+func (tel *Telemetry) PrintResourceProgress(r *Resource, usableWidth uint) {
+	rss := r.Segments()
+	color := GetTelemetryProgressBarColor(tel.resourceColorMap[r])
+
+	resourceBarWidth := tel.GetResourceBarWidth(r, usableWidth)
+
+	for _, rs := range rss {
+		tel.PrintResourceSegmentProgress(rs, &color, uint(resourceBarWidth))
+	}
+}
+
+func GetTelemetryProgressBarColor(themeColor float64) TelemetryProgressBarColor {
+	fr, fg, fb, _ := cc.HSVToRGB(themeColor, 1, 1)
+	br, bg, bb, _ := cc.HSVToRGB(themeColor, 1, 0.2)
+	return TelemetryProgressBarColor{
+		fr: fr,
+		fg: fg,
+		fb: fb,
+		br: br,
+		bg: bg,
+		bb: bb}
+}
+
+func (tel *Telemetry) PrintResourceSegmentProgress(rs *ResourceSegment, color *TelemetryProgressBarColor, resourceBarWidth uint) {
+	idStr := fmt.Sprintf("%d_%d", tel.resourceIdMap[rs.resource], tel.segmentIdMap[rs])
+
+	// nothing to draw for an empty segment or a streaming resource, the length of which is unknown
+	from, to, ack := rs.Progress()
+	if to == from || resourceBarWidth == 0 {
+		return
+	}
+
+	r := rs.resource
+	pct := float64(to-from) / float64(r.contentLength)
+	barWidth := int(math.Round(float64(resourceBarWidth) * pct))
+	dwnProgress := min(ack-from, to-from)
+	filledWidth := int(math.Ceil(float64(dwnProgress) / float64(to-from) * float64(barWidth)))
+	unfilledWidth := max(barWidth-filledWidth, 0)
+
+	if barWidth > len(idStr) {
+		idStrPart1 := idStr
+		if filledWidth < len(idStr) {
+			idStrPart1 = idStr[:filledWidth]
+		}
+		filledPart := fmt.Sprintf("%-"+strconv.Itoa(filledWidth)+"s", idStrPart1)
+		tel.screen.WriteString(tm.BackgroundRGB(filledPart, color.fr, color.fg, color.fb))
+
+		idStrPart2 := ""
+		if filledWidth < len(idStr) {
+			idStrPart2 = idStr[filledWidth:]
+		}
+		unfilledPart := fmt.Sprintf("%-"+strconv.Itoa(unfilledWidth)+"s", idStrPart2)
+		tel.screen.WriteString(tm.BackgroundRGB(unfilledPart, color.br, color.bg, color.bb))
+	} else {
+		tel.screen.WriteString(tm.BackgroundRGB(strings.Repeat(" ", filledWidth), color.fr, color.fg, color.fb))
+		tel.screen.WriteString(tm.BackgroundRGB(strings.Repeat(" ", unfilledWidth), color.br, color.bg, color.bb))
+	}
+}
+
+// ReportWidth returns the width of the terminal, or 80 if the report is not written to a terminal.
+func ReportWidth() int {
+	if width := tm.Width(); width > 0 {
+		return width
+	}
+	return 80
+}
+
+func SignedInt[T ~int | ~int8 | ~int16 | ~int32 | ~int64 |
+	~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64](n T) string {
+	if n < 0 {
+		return strconv.Itoa(int(n))
+	}
+	return "+" + strconv.Itoa(int(n))
+}
@@ -0,0 +1,119 @@
+package downloader
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const THROTTLE_MIN_BACKOFF = time.Second          // the back-off of the first throttling response without Retry-After
+const THROTTLE_MAX_BACKOFF = time.Minute          // the back-off doubles on every throttling response up to this
+const THROTTLE_MAX_RETRY_AFTER = 10 * time.Minute // a longer Retry-After is capped, it would stall the download
+
+// IsThrottlingStatusCode returns true if the server asks the client to slow down.
+func IsThrottlingStatusCode(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+/*
+ParseRetryAfter parses the Retry-After header in either seconds or an HTTP date. It returns 0 if the header is
+missing or invalid.
+*/
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		retryAfter = date.Sub(now)
+	}
+
+	return min(max(retryAfter, 0), THROTTLE_MAX_RETRY_AFTER)
+}
+
+type ThrottleState struct {
+	until   time.Time
+	backoff time.Duration // the back-off of the next throttling response without Retry-After
+}
+
+/*
+Throttler backs off a proxy IP from an origin host which responded 429 or 503, for the time of the Retry-After header
+or an exponential back-off from THROTTLE_MIN_BACKOFF to THROTTLE_MAX_BACKOFF. The downloaders of the proxy IP are not
+assigned the segments of the origin host until then, and can download the other resources meanwhile. A successful
+download resets the back-off.
+*/
+type Throttler struct {
+	mutex  sync.Mutex
+	states map[ConnectionKey]*ThrottleState
+}
+
+func NewThrottler() *Throttler {
+	return &Throttler{states: make(map[ConnectionKey]*ThrottleState)}
+}
+
+// ReportThrottled backs off the proxy IP of the downloader from the origin host and returns the back-off.
+func (th *Throttler) ReportThrottled(dwn *Downloader, r *Resource, retryAfter time.Duration) time.Duration {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	key := ToConnectionKey(dwn, r.Host())
+	state, ok := th.states[key]
+	if !ok {
+		state = &ThrottleState{backoff: THROTTLE_MIN_BACKOFF}
+		th.states[key] = state
+	}
+
+	backoff := retryAfter
+	if backoff == 0 {
+		backoff = state.backoff
+		state.backoff = min(state.backoff*2, THROTTLE_MAX_BACKOFF)
+	}
+
+	state.until = time.Now().Add(backoff)
+	return backoff
+}
+
+// ReportSuccess resets the back-off, a download started before the throttling response does not end the current one.
+func (th *Throttler) ReportSuccess(dwn *Downloader, r *Resource) {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	key := ToConnectionKey(dwn, r.Host())
+	if state, ok := th.states[key]; ok {
+		if time.Now().Before(state.until) {
+			state.backoff = THROTTLE_MIN_BACKOFF
+		} else {
+			delete(th.states, key)
+		}
+	}
+}
+
+// IsAllowed returns false if the proxy IP of the downloader is backing off from the origin host of the resource.
+func (th *Throttler) IsAllowed(dwn *Downloader, r *Resource) bool {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	state, ok := th.states[ToConnectionKey(dwn, r.Host())]
+	return !ok || !time.Now().Before(state.until)
+}
+
+// NextExpiry returns the time until the earliest back-off ends, or 0 if nothing is backing off.
+func (th *Throttler) NextExpiry() time.Duration {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	now := time.Now()
+	next := time.Duration(0)
+	for _, state := range th.states {
+		if left := state.until.Sub(now); left > 0 && (next == 0 || left < next) {
+			next = left
+		}
+	}
+	return next
+}
@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+Timeouts are the limits of every phase of a request, 0 means no limit. The dial timeout includes the handshake of
+the SOCKS5 proxies, and the idle-read timeout is the longest wait for the next bytes of the response body, the
+segment is stalled if it is exceeded, see STALLED.
+*/
+type Timeouts struct {
+	dial           time.Duration
+	tlsHandshake   time.Duration
+	responseHeader time.Duration // from the request is sent to the response header is received
+	idleRead       time.Duration
+}
+
+func DefaultTimeouts() *Timeouts {
+	return &Timeouts{dial: 10 * time.Second, tlsHandshake: 10 * time.Second, responseHeader: 15 * time.Second, idleRead: 30 * time.Second}
+}
+
+/*
+ParseTimeouts parses a comma separated list of 'key=duration', where the key is dial, tls, header or idle and the
+duration is in the format of time.ParseDuration, e.g. 'dial=5s,idle=1m'. The phases not in the list keep the default.
+*/
+func ParseTimeouts(raw string) (*Timeouts, error) {
+	timeouts := DefaultTimeouts()
+	for _, attr := range strings.Split(raw, ",") {
+		attr = strings.TrimSpace(attr)
+		if attr == "" {
+			continue
+		}
+
+		key, rawValue, found := strings.Cut(attr, "=")
+		if !found {
+			return nil, fmt.Errorf("the timeouts must be in the format of 'key=duration': %s", attr)
+		}
+		value, err := time.ParseDuration(rawValue)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("the timeout of %s must be a non-negative duration: %s", key, rawValue)
+		}
+
+		switch key {
+		case "dial":
+			timeouts.dial = value
+		case "tls":
+			timeouts.tlsHandshake = value
+		case "header":
+			timeouts.responseHeader = value
+		case "idle":
+			timeouts.idleRead = value
+		default:
+			return nil, fmt.Errorf("unknown timeout: %s", key)
+		}
+	}
+	return timeouts, nil
+}
+
+// NewTransport creates the transport of a downloader with the dial, TLS handshake and response header timeouts.
+func (t *Timeouts) NewTransport() *http.Transport {
+	transport := &http.Transport{}
+	transport.DialContext = (&net.Dialer{Timeout: t.dial}).DialContext
+	transport.TLSHandshakeTimeout = t.tlsHandshake
+	transport.ResponseHeaderTimeout = t.responseHeader
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // set ssl
+	return transport
+}
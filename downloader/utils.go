@@ -0,0 +1,64 @@
+package downloader
+
+import (
+	"sort"
+	"sync"
+)
+
+type ThreadSafeQueue[T any] struct {
+	list  []*T
+	mutex sync.Mutex
+}
+
+func (q *ThreadSafeQueue[T]) Push(item *T) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.list = append(q.list, item)
+}
+
+func (q *ThreadSafeQueue[T]) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.list)
+}
+
+// PopFunc removes and returns the first item satisfying the predicate, or nil if there is none.
+func (q *ThreadSafeQueue[T]) PopFunc(predicate func(item *T) bool) *T {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// Time complexity: O(n)
+	for i, item := range q.list {
+		if predicate(item) {
+			q.list = append(q.list[:i], q.list[i+1:]...)
+			return item
+		}
+	}
+
+	return nil
+}
+
+func (q *ThreadSafeQueue[T]) Remove(item *T) bool {
+	return q.PopFunc(func(listItem *T) bool {
+		return listItem == item
+	}) != nil
+}
+
+// Items returns a copy of the items in the queue.
+func (q *ThreadSafeQueue[T]) Items() []*T {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return append([]*T{}, q.list...)
+}
+
+func (q *ThreadSafeQueue[T]) Sort(less func(i, j *T) bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	sort.SliceStable(q.list, func(i, j int) bool {
+		return less(q.list[i], q.list[j])
+	})
+}
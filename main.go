@@ -1,18 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"math"
+	"io"
 	"os"
-	"sort"
+	"os/signal"
 	"strings"
+	"syscall"
+
+	"github.com/Jerrylum/Project5296-ClientTool/downloader"
 )
 
-func ReadFileByLine(path string) []string {
+func ReadFileByLine(path string) ([]string, error) {
 	dat, err := os.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	var rtn []string
@@ -22,21 +26,46 @@ func ReadFileByLine(path string) []string {
 		}
 	}
 
-	return rtn
+	return rtn, nil
 }
 
-func IsAllResourceRequestAvailable(requests ResourceRequestList) bool {
-	for _, request := range requests {
-		if request.status != AVAILABLE {
-			return false
+// Confirm asks the question on the terminal until the answer is y or n, it is false if the context is done first.
+func Confirm(ctx context.Context, out io.Writer, question string) bool {
+	answer := make(chan bool, 1)
+	go func() {
+		for {
+			fmt.Fprint(out, question)
+			input := ""
+			_, err := fmt.Scanln(&input)
+			if strings.ToLower(input) == "y" {
+				answer <- true
+				return
+			} else if strings.ToLower(input) == "n" || err == io.EOF {
+				answer <- false
+				return
+			}
 		}
-	}
+	}()
 
-	return true
+	select {
+	case <-ctx.Done():
+		return false
+	case isConfirmed := <-answer:
+		return isConfirmed
+	}
 }
 
 func main() {
-	proxyListPathRaw := flag.String("proxies", "", "The path to a file with a list of proxy server ips, separated by linefeed")
+	proxyListPathRaw := flag.String("proxies", "", `The path to a file with a list of proxy servers, separated by linefeed
+Each line is in the format of '[scheme://][user:pass@]host[:port] [key=value ...]'
+   e.g. '127.0.0.1', 'socks5://user:pass@[::1]:1080 weight=2 max-connections=4'
+   The scheme can be http, https or socks5 and is http by default. The port is 3000 by default
+   weight is the number of connections assigned to the proxy in each round, 1 by default
+   max-connections is the maximum number of connections of the proxy, unlimited by default
+   max-connections-per-host is the maximum number of concurrent connections from the proxy IP to an origin host,
+   -maxConnectionsPerHost by default
+   rate-limit is the maximum rate of all connections of the proxy in bytes per second, e.g. 'rate-limit=1M'
+`)
 	requestListPathRaw := flag.String("requests", "", `The path to a file with a list of download requests, separated by linefeed
 Each line can be one of the following formats:
  - Only the URL
@@ -48,143 +77,150 @@ Each line can be one of the following formats:
  - URL with specified file path
    e.g. 'http://example.com/file.zip > /path/to/save/file.zip'
    The file will be saved to the specified path
+Any of the formats above can be followed by the expected checksum of the file (sha256, sha1 or md5)
+   e.g. 'http://example.com/file.zip > /path/to/save/ # sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855'
+   The file will be verified after it is downloaded and reported as failed if the checksum does not match
+A line can also be a JSON object with the fields url, dest, headers, expectedSize, checksum, priority, mirrors and rateLimit
+   e.g. '{"url": "http://example.com/file.zip", "dest": "/path/to/save/", "headers": {"Cookie": "a=b"}, "priority": 1}'
+   Only url is required, dest and checksum are the same as above, resources with a higher priority are downloaded first
+   and the mirrors are the alternative urls of the same file, tried in order if the url is not available
+   rateLimit is the maximum download rate of the resource in bytes per second, e.g. "512K"
 `)
-	numOfConnRaw := flag.Int("connections", 0, "The number of connections in total to download")
+	numOfConnRaw := flag.Int("connections", 0, "The number of connections in total to download, or the maximum number with -autoConnections")
+	autoConnections := flag.Bool("autoConnections", false, `Tune the number of active connections of every proxy from the measured throughput
+Every proxy starts with one connection and gets one more as long as it adds bandwidth, the connections are halved
+if the downloads start failing. The maximum is '-connections' in total or 16 per proxy if not provided`)
 	logFilePathRaw := flag.String("log", "", "The path to the log file. If not provided, the log will be discarded.")
 	name := flag.String("name", "default", "The name of the current execution. If not provided, the name will be 'default'")
 	timeLogFilePathRaw := flag.String("timeLog", "", "The path to the time log file. If not provided, the log will be discarded.")
+	plan := flag.Bool("plan", false, `Measure the throughput of each connection with a ranged probe before the download,
+and size the initial segments proportionally to the throughput instead of equally`)
+	planProbeSize := flag.Uint64("planProbeSize", 1024*1024, "The number of bytes downloaded by each connection to measure the throughput, used with -plan")
+	maxConnectionsPerHost := flag.Int("maxConnectionsPerHost", 0, `The maximum number of concurrent connections from one proxy IP to one origin host, 0 means unlimited
+It can be overridden per proxy with the max-connections-per-host attribute in the proxy list`)
+	rateLimitRaw := flag.String("rateLimit", "0", "The maximum download rate of all connections in bytes per second with an optional K, M or G suffix, 0 means unlimited")
+	retryPolicyRaw := flag.String("retryPolicy", "ttl=3", `The number of attempts of every segment and the retries of each error class, e.g. 'ttl=5,dns=0,status-4xx=1'
+The error classes are dns, dial-timeout, refused, unreachable, tls, reset, timeout, short-read, status-4xx, status-5xx,
+throttled, write and other. A segment is given up once it fails more times than the retries of the class or runs out of ttl
+The throttled responses (429 and 503) do not take ttl, they are retried 10 times unless given`)
+	schedulerName := flag.String("scheduler", "lpt", `The strategy to order and split the segments, one of:
+lpt: the largest segments first, the largest in-progress segment is split in half
+round-robin: one segment of every resource in turn in the order of the request list, the in-progress segments are split in half in turn
+bandwidth-proportional: like lpt, but the slowest segment is split proportionally to the throughput of the connections
+shortest-remaining-first: the resource with the fewest remaining bytes first, to complete the resources one by one`)
+	timeoutsRaw := flag.String("timeouts", "dial=10s,tls=10s,header=15s,idle=30s", `The timeouts of every phase of a request, e.g. 'dial=5s,idle=1m', 0 means no timeout
+dial: the connection to the proxy, including the SOCKS5 handshake
+tls: the TLS handshake
+header: from the request is sent to the response header is received, it bounds the preflight as well
+idle: the longest wait for the next bytes of the response body, the stalled segment is retried from where it stopped`)
+	endgame := flag.Bool("endgame", true, "Race the slowest segments with the idle connections at the end of the download, the first one to finish is kept")
+	onUnavailable := flag.String("onUnavailable", downloader.ON_UNAVAILABLE_PROMPT, `What to do if some resources are not available before the download, one of:
+prompt: ask whether to continue downloading the available resources
+skip: continue downloading the available resources
+abort: download nothing`)
+	summaryPath := flag.String("summary", "", `The path to write a JSON summary of the result of every resource to, '-' for the standard output
+The messages, the progress and the report are written to the standard error instead if the summary is written to the standard output
+The process exits with 0 if every resource is downloaded, 1 if some resources are unavailable or failed to download,
+2 if the options are invalid, 3 if nothing is downloaded, e.g. no resource is available or the download is aborted,
+and 130 if the preflight or the download is interrupted by a signal`)
+	resume := flag.Bool("resume", false, `Resume the download from the journal files saved next to the destinations
+The progress of every resource is saved to '<dest>.journal' during the download and removed once the resource is completed`)
 
 	flag.Parse()
 
+	// the summary written to the standard output is not mixed with anything else, so it can be piped
+	var out io.Writer = os.Stdout
+	if *summaryPath == "-" {
+		out = os.Stderr
+	}
+
 	if *proxyListPathRaw == "" && *requestListPathRaw == "" && *numOfConnRaw == 0 {
 		flag.PrintDefaults()
 		return
 	}
 
 	if *proxyListPathRaw == "" {
-		fmt.Println("Please provide a list of proxy servers")
-		os.Exit(1)
+		fmt.Fprintln(out, "Please provide a list of proxy servers")
+		os.Exit(downloader.EXIT_USAGE_ERROR)
 	}
 
 	if *requestListPathRaw == "" {
-		fmt.Println("Please provide a list of urls to download")
-		os.Exit(1)
+		fmt.Fprintln(out, "Please provide a list of urls to download")
+		os.Exit(downloader.EXIT_USAGE_ERROR)
 	}
 
-	if *numOfConnRaw == 0 {
-		fmt.Println("Please provide the number of connections")
-		os.Exit(1)
+	if *numOfConnRaw == 0 && !*autoConnections {
+		fmt.Fprintln(out, "Please provide the number of connections")
+		os.Exit(downloader.EXIT_USAGE_ERROR)
 	}
 
-	if *numOfConnRaw < 0 {
-		fmt.Println("The number of connections must be greater than 0")
-		os.Exit(1)
+	rateLimit, err := downloader.ParseByteRate(*rateLimitRaw)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		os.Exit(downloader.EXIT_USAGE_ERROR)
 	}
 
-	proxyIps := IpList(ReadFileByLine(*proxyListPathRaw))
-	originalUserRequests := OriginalUserRequestList(ReadFileByLine(*requestListPathRaw))
-
-	numOfConn := *numOfConnRaw
-	downloaders := proxyIps.ToDownloaderCluster(numOfConn)
-	userRequests := originalUserRequests.ToUserRequests()
-	allResourceRequests := downloaders.FetchResourceRequests(userRequests)
-
-	/////////////////////////
-	/// Check if all resources are available
-	/////////////////////////
-
-	resourceRequests := ResourceRequestList{}
-
-	if len(downloaders) == 0 {
-		fmt.Println("No downloader available")
-		os.Exit(1)
+	retryPolicy, err := downloader.ParseRetryPolicy(*retryPolicyRaw)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		os.Exit(downloader.EXIT_USAGE_ERROR)
 	}
 
-	if !IsAllResourceRequestAvailable(allResourceRequests) {
-		fmt.Println("The following resources are not available.")
-
-		for _, rr := range allResourceRequests {
-			if rr.status == AVAILABLE {
-				resourceRequests = append(resourceRequests, rr)
-			} else {
-				switch rr.status {
-				case NOT_FOUND:
-					fmt.Printf("Status code != 200: %s, fetched by proxy %s\n", rr.url, telemetry.GetDownloaderIp(rr.fetchedBy))
-				case CONNECTION_TIMEOUT:
-					fmt.Printf("Connection timeout: %s, fetched by proxy %s\n", rr.url, telemetry.GetDownloaderIp(rr.fetchedBy))
-				case CONNECTION_REFUSED:
-					fmt.Printf("Connection refused: %s, fetched by proxy %s\n", rr.url, telemetry.GetDownloaderIp(rr.fetchedBy))
-				}
-			}
-		}
-
-		for {
-			fmt.Print("Do you want to continue downloading the available resources (y/n)? ")
-			input := ""
-			fmt.Scanln(&input)
-			if strings.ToLower(input) == "y" {
-				break
-			} else if strings.ToLower(input) == "n" {
-				os.Exit(0)
-			}
-		}
-	} else {
-		resourceRequests = allResourceRequests
+	timeouts, err := downloader.ParseTimeouts(*timeoutsRaw)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		os.Exit(downloader.EXIT_USAGE_ERROR)
 	}
 
-	if len(resourceRequests) == 0 {
-		fmt.Println("No resource to download")
-		os.Exit(1)
+	// the download stops on the first interrupt or termination signal, a second one terminates the process at once
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	options := downloader.DefaultOptions()
+	options.Connections = *numOfConnRaw
+	options.IsAutoConnectionsEnabled = *autoConnections
+	options.MaxConnectionsPerHost = *maxConnectionsPerHost
+	options.RateLimit = rateLimit
+	options.RetryPolicy = retryPolicy
+	options.Timeouts = timeouts
+	options.Scheduler = *schedulerName
+	options.IsPlanEnabled = *plan
+	options.PlanProbeSize = *planProbeSize
+	options.IsEndgameEnabled = *endgame
+	options.IsResumeEnabled = *resume
+	options.OnUnavailable = *onUnavailable
+	options.Confirm = func(unavailable []downloader.SummaryResource) bool {
+		return Confirm(ctx, out, "Do you want to continue downloading the available resources (y/n)? ")
 	}
+	options.Output = out
+	options.IsProgressShown = true
+	options.LogPath = *logFilePathRaw
+	options.Name = *name
+	options.TimeLogPath = *timeLogFilePathRaw
 
-	/////////////////////////
-	/// Init telemetry and start download process
-	/////////////////////////
-
-	telemetry.Init(*logFilePathRaw, *name, *timeLogFilePathRaw)
-
-	/////////////////////////
-	/// Calculate the chunk size for each downloader
-	/////////////////////////
-
-	chunkSize := uint64(math.Ceil(float64(resourceRequests.TotalContentLength()) / float64(len(downloaders))))
-
-	/////////////////////////
-	/// Create resources and split them into segments
-	/////////////////////////
-
-	resources := resourceRequests.ToResources(chunkSize)
-
-	/////////////////////////
-	/// Sort the segments by the size from largest to smallest
-	/////////////////////////
-
-	segments := []*ResourceSegment{}
-
-	for _, resource := range resources {
-		segments = append(segments, resource._segments...)
+	requests, err := ReadFileByLine(*requestListPathRaw)
+	if err != nil {
+		fmt.Fprintln(out, "Unable to read the list of urls:", err)
+		os.Exit(downloader.EXIT_USAGE_ERROR)
 	}
 
-	// We want to download the largest segments first to better balance the load among the downloaders
-	sort.Slice(segments, func(i, j int) bool {
-		return segments[i].ContentLength() > segments[j].ContentLength()
-	})
-
-	/////////////////////////
-	/// Download the segments
-	/////////////////////////
-
-	telemetry.Start(&downloaders, &resourceRequests, &resources, &segments)
-
-	downloaders.Download(&segments)
-
-	telemetry.End()
-
-	/////////////////////////
-	/// Print the report
-	/////////////////////////
+	proxies, err := ReadFileByLine(*proxyListPathRaw)
+	if err != nil {
+		fmt.Fprintln(out, "Unable to read the list of proxy servers:", err)
+		os.Exit(downloader.EXIT_USAGE_ERROR)
+	}
 
-	fmt.Println("\n\nDownload completed")
+	summary, err := options.Run(ctx, requests, proxies)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		os.Exit(downloader.EXIT_USAGE_ERROR)
+	}
 
-	telemetry.PrintReport()
+	if *summaryPath != "" {
+		if err := summary.Write(*summaryPath); err != nil {
+			fmt.Fprintln(out, "Unable to write the summary:", err)
+		}
+	}
+	os.Exit(summary.ExitCode)
 }